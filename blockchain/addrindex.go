@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+)
+
+var (
+	addrIndexPrefix = []byte("addr-")
+	addrIndexFlag   = []byte("addrindex")
+)
+
+// AddressEntry is one main chain transaction that credits or debits an address
+type AddressEntry struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Timestamp int64
+	Received  int
+	Sent      int
+}
+
+// AddressHistory lists an address's transactions oldest first with totals
+type AddressHistory struct {
+	PubKeyHash []byte
+	Entries    []AddressEntry
+	Received   int
+	Sent       int
+	Balance    int
+}
+
+func addrIndexKey(pubKeyHash, txID []byte) []byte {
+	key := append([]byte{}, addrIndexPrefix...)
+	key = append(key, pubKeyHash...)
+	return append(key, txID...)
+}
+
+// AddrIndexEnabled reports whether the address index is kept up to date.
+// It is switched on by ReindexAddresses when the chain is created or, for
+// chains that predate the index, first opened.
+func (chain *BlockChain) AddrIndexEnabled() bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(addrIndexFlag)
+		return err
+	})
+	return err == nil
+}
+
+// ReindexAddresses rebuilds the address index from the main chain and
+// keeps it enabled from then on
+func (chain *BlockChain) ReindexAddresses() {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.DeleteByPrefix(addrIndexPrefix)
+
+	blocks, err := chain.GetBlocksByHeight(0, chain.GetBestHeight())
+	Handle(err)
+
+	outputs := make(map[string][]TxOutput)
+	for i := range blocks {
+		chain.indexAddresses(&blocks[i], true, func(id []byte) []TxOutput {
+			return outputs[hex.EncodeToString(id)]
+		})
+		for _, tx := range blocks[i].Transactions {
+			outputs[hex.EncodeToString(tx.ID)] = tx.Outputs
+		}
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(addrIndexFlag, []byte{1})
+	})
+	Handle(err)
+}
+
+// spentOutputs resolves spent outputs for indexAddresses of a block just
+// connected to the UTXO set. The undo data holds every output it spends as
+// it was before the block; outputs created and spent within the block come
+// from the block itself. Blocks connected by a full reindex have no undo
+// data and are looked up on the main chain instead.
+func (chain *BlockChain) spentOutputs(block *Block) func([]byte) []TxOutput {
+	outputs := make(map[string][]TxOutput)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		undo, err := loadUndo(txn, block.Hash)
+		if err != nil {
+			return err
+		}
+		for _, entry := range undo {
+			if entry.Existed && bytes.HasPrefix(entry.Key, utxoPrefix) {
+				outputs[hex.EncodeToString(entry.Key[prefixLength:])] = DeserializeOutputs(entry.Value).Outputs
+			}
+		}
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return func(id []byte) []TxOutput {
+			tx, err := chain.FindTransaction(id)
+			if err != nil {
+				return nil
+			}
+			return tx.Outputs
+		}
+	}
+	Handle(err)
+
+	for _, tx := range block.Transactions {
+		key := hex.EncodeToString(tx.ID)
+		if _, ok := outputs[key]; !ok {
+			outputs[key] = tx.Outputs
+		}
+	}
+	return func(id []byte) []TxOutput {
+		return outputs[hex.EncodeToString(id)]
+	}
+}
+
+// indexAddresses adds an entry for every address the block's transactions
+// pay to or spend from, or removes them when the block is disconnected.
+// prevOutputs returns the outputs of the transaction an input spends.
+func (chain *BlockChain) indexAddresses(block *Block, connect bool, prevOutputs func([]byte) []TxOutput) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for _, tx := range block.Transactions {
+			entries := make(map[string]*AddressEntry)
+			entry := func(pubKeyHash []byte) *AddressEntry {
+				e, ok := entries[string(pubKeyHash)]
+				if !ok {
+					e = &AddressEntry{TxID: tx.ID, BlockHash: block.Hash, Height: block.Height, Timestamp: block.Timestamp}
+					entries[string(pubKeyHash)] = e
+				}
+				return e
+			}
+
+			for _, out := range tx.Outputs {
+				entry(out.PubKeyHash).Received += out.Value
+			}
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					e := entry(wallet.PublicKeyHash(in.PubKey))
+					if !connect {
+						continue
+					}
+					if outs := prevOutputs(in.ID); in.Out < len(outs) {
+						e.Sent += outs[in.Out].Value
+					}
+				}
+			}
+
+			for pubKeyHash, e := range entries {
+				key := addrIndexKey([]byte(pubKeyHash), tx.ID)
+				if !connect {
+					if err := txn.Delete(key); err != nil {
+						return err
+					}
+					continue
+				}
+				var encoded bytes.Buffer
+				if err := gob.NewEncoder(&encoded).Encode(e); err != nil {
+					return err
+				}
+				if err := txn.Set(key, encoded.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	Handle(err)
+}
+
+// AddressHistory returns every main chain transaction touching pubKeyHash
+func (chain *BlockChain) AddressHistory(pubKeyHash []byte) AddressHistory {
+	history := AddressHistory{PubKeyHash: pubKeyHash}
+	prefix := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var entry AddressEntry
+			if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&entry); err != nil {
+				return err
+			}
+			history.Entries = append(history.Entries, entry)
+			history.Received += entry.Received
+			history.Sent += entry.Sent
+		}
+		return nil
+	})
+	Handle(err)
+
+	sort.SliceStable(history.Entries, func(i, j int) bool {
+		return history.Entries[i].Height < history.Entries[j].Height
+	})
+	history.Balance = history.Received - history.Sent
+	return history
+}
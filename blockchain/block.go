@@ -2,106 +2,220 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"time"
 )
 
+// gob hands out type ids in the order types are first seen by the process.
+// Registering the whole block type tree up front keeps the serialized bytes,
+// and with them transaction and Merkle hashes, identical across processes.
+func init() {
+	err := gob.NewEncoder(io.Discard).Encode(Block{Transactions: []*Transaction{{}}})
+	Handle(err)
+}
+
+// Records groups the non-value transactions a block can carry
+type Records struct {
+	FileTxs      []*FileUploadTransaction
+	BlocklistTxs []*BlocklistTransaction
+	SnapshotTxs  []*SnapshotTransaction
+	VoteTxs      []*ValidatorVote
+}
+
 type Block struct {
 	Timestamp    int64
 	Hash         []byte
-	Transactions []*Transaction         // Standard transactions
-	FileTx       *FileUploadTransaction // Optional file transaction
+	MerkleRoot   []byte
+	Transactions []*Transaction // Standard transactions
+	Records                     // File, blocklist and snapshot transactions
 	PrevHash     []byte
 	Nonce        int
 	Height       int
+	Bits         uint32 // Compact proof-of-work target, 0 on blocks from before retargeting
+	Signer       []byte // Public key of the proof-of-authority validator
+	Signature    []byte
+	Version      int // BlockVersion, 0 on blocks hashed with gob
 }
 
-// Calculates the hash for blocks with or without file uploads
-func (b *Block) SetHash() {
-	timestamp := []byte(time.Unix(b.Timestamp, 0).String())
-
-	var data []byte
-
-	if b.FileTx != nil {
-		data = append(data, b.FileTx.FileHash...)
-		data = append(data, b.FileTx.Filename...)
-	} else if len(b.Transactions) > 0 {
-		data = append(data, b.HashTransactions()...)
-	}
-
-	headers := bytes.Join([][]byte{
-		b.PrevHash,
-		timestamp,
-		data,
-	}, []byte{})
-
-	hash := sha256.Sum256(headers)
-	b.Hash = hash[:]
-}
-
-// Create a regular transaction block (with PoW)
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
+// Create a block and seal it with PoW against the target in bits. Value
+// transactions and records are all committed through the Merkle root.
+func CreateBlock(txs []*Transaction, records Records, prevHash []byte, height int, bits uint32) *Block {
 	block := &Block{
 		Timestamp:    time.Now().Unix(),
 		Transactions: txs,
+		Records:      records,
 		PrevHash:     prevHash,
 		Height:       height,
+		Bits:         bits,
+		Version:      BlockVersion,
 	}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-	block.Hash = hash[:]
-	block.Nonce = nonce
-
-	return block
-}
+	block.MerkleRoot = block.HashTransactions()
+	err := ProofOfWorkEngine{}.Seal(context.Background(), block)
+	Handle(err)
 
-// Create a file-based block
-func NewFileBlock(tx *FileUploadTransaction, prevHash []byte, height int) *Block {
-	block := &Block{
-		Timestamp: time.Now().Unix(),
-		FileTx:    tx,
-		PrevHash:  prevHash,
-		Height:    height,
-	}
-	block.SetHash()
 	return block
 }
 
 // Genesis block (first block in chain)
 func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return CreateBlock([]*Transaction{coinbase}, Records{}, []byte{}, 0, LegacyBits)
 }
 
-// Calculate Merkle Root from standard transactions
+// Calculate Merkle Root over every transaction and record in the block.
+// Records are tagged by kind so leaves of different types cannot collide.
+// Version 0 blocks build their leaves from gob.
 func (b *Block) HashTransactions() []byte {
 	var txHashes [][]byte
+	legacy := b.Version == 0
 
 	for _, tx := range b.Transactions {
+		if legacy {
+			txHashes = append(txHashes, tx.legacySerialize())
+			continue
+		}
 		txHashes = append(txHashes, tx.Serialize())
 	}
+	for _, tx := range b.FileTxs {
+		hash := tx.hashAt(b.Version)
+		txHashes = append(txHashes, append([]byte("file:"), hash...))
+	}
+	for _, tx := range b.BlocklistTxs {
+		hash := tx.Hash()
+		if legacy {
+			hash = tx.legacyHash()
+		}
+		txHashes = append(txHashes, append([]byte("blocklist:"), hash...))
+	}
+	for _, tx := range b.SnapshotTxs {
+		hash := tx.Hash()
+		if legacy {
+			hash = tx.legacyHash()
+		}
+		txHashes = append(txHashes, append([]byte("snapshot:"), hash...))
+	}
+	for _, vote := range b.VoteTxs {
+		hash := vote.hashAt(b.Version)
+		txHashes = append(txHashes, append([]byte("vote:"), hash...))
+	}
+
+	if len(txHashes) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
 	tree := NewMerkleTree(txHashes)
 
 	return tree.RootNode.Data
 }
 
-// Serialize block for DB
+// IsEmpty reports whether the records carry nothing
+func (r Records) IsEmpty() bool {
+	return len(r.FileTxs) == 0 && len(r.BlocklistTxs) == 0 && len(r.SnapshotTxs) == 0 && len(r.VoteTxs) == 0
+}
+
+// Verify checks the records can be included in a new block
+func (r Records) Verify() error {
+	return r.verify(BlockVersion)
+}
+
+// verify checks the records by the rules of the block version they are in
+func (r Records) verify(version int) error {
+	for _, tx := range r.FileTxs {
+		if !IsFileHash(tx.FileHash) {
+			return errors.New("file transaction has an invalid file hash")
+		}
+		if version > 0 && !tx.Verify() {
+			return fmt.Errorf("file transaction for %s is not signed by %s", tx.FileHash, tx.FromAddress)
+		}
+	}
+	for _, tx := range r.BlocklistTxs {
+		if !tx.Verify() {
+			return errors.New("blocklist transaction has an invalid signature")
+		}
+		if !tx.Authorised() {
+			return fmt.Errorf("%s may not change the blocklist", tx.Address())
+		}
+	}
+	for _, tx := range r.SnapshotTxs {
+		if !tx.Validate() {
+			return errors.New("snapshot manifest does not match its root")
+		}
+	}
+	for _, vote := range r.VoteTxs {
+		if !vote.verifyAt(version) {
+			return errors.New("validator vote has an invalid signature")
+		}
+	}
+	return nil
+}
+
+// Serialize block for DB and the wire in the canonical encoding
 func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
-	err := encoder.Encode(b)
-	Handle(err)
-	return res.Bytes()
+	e := newEncoder()
+	e.block(b)
+	return e.buf
 }
 
 // Deserialize block from DB
 func Deserialize(data []byte) *Block {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	block, err := DecodeBlock(data)
 	Handle(err)
-	return &block
+	return block
+}
+
+// DecodeBlock is Deserialize for untrusted input
+func DecodeBlock(data []byte) (*Block, error) {
+	d := newDecoder(data)
+	block := d.block()
+	return block, d.finish()
+}
+
+// legacyBlock is Block as it was stored with gob. Blocks from before
+// records were grouped carry their one file transaction in FileTx; gob
+// skips fields the target lacks, so it needs a field of its own.
+type legacyBlock struct {
+	Timestamp    int64
+	Hash         []byte
+	MerkleRoot   []byte
+	Transactions []*Transaction
+	FileTx       *FileUploadTransaction
+	Records
+	PrevHash  []byte
+	Nonce     int
+	Height    int
+	Bits      uint32
+	Signer    []byte
+	Signature []byte
+	Version   int
+}
+
+// deserializeLegacy reads a block stored with gob
+func deserializeLegacy(data []byte) (*Block, error) {
+	var legacy legacyBlock
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy)
+	block := &Block{
+		Timestamp:    legacy.Timestamp,
+		Hash:         legacy.Hash,
+		MerkleRoot:   legacy.MerkleRoot,
+		Transactions: legacy.Transactions,
+		Records:      legacy.Records,
+		PrevHash:     legacy.PrevHash,
+		Nonce:        legacy.Nonce,
+		Height:       legacy.Height,
+		Bits:         legacy.Bits,
+		Signer:       legacy.Signer,
+		Signature:    legacy.Signature,
+		Version:      legacy.Version,
+	}
+	if legacy.FileTx != nil {
+		block.FileTxs = append([]*FileUploadTransaction{legacy.FileTx}, block.FileTxs...)
+	}
+	return block, err
 }
 
 // Simple error handling
@@ -1,10 +1,11 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -13,18 +14,15 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger"
 )
 
-const (
-	dbPath      = "./tmp/blocks_%s"
-	genesisData = "First Transaction from Genesis"
-)
-
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
+	Engine   ConsensusEngine // Proof-of-work when nil
 }
 
 func DBexists(path string) bool {
@@ -35,7 +33,7 @@ func DBexists(path string) bool {
 }
 
 func ContinueBlockChain(nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
+	path := fmt.Sprintf(Params.DBPath, nodeId)
 	if !DBexists(path) {
 		fmt.Println("No existing blockchain found, create one!")
 		runtime.Goexit()
@@ -56,91 +54,72 @@ func ContinueBlockChain(nodeId string) *BlockChain {
 	})
 	Handle(err)
 
-	return &BlockChain{lastHash, db}
+	chain := &BlockChain{LastHash: lastHash, Database: db}
+	// Chains stored with gob are converted once to the canonical encoding
+	if !chain.encodingMigrated() {
+		fmt.Printf("Converted %d blocks to the canonical encoding\n", chain.MigrateEncoding())
+	}
+	chain.checkParams()
+	// Chains stored before the height index existed get it built here
+	tip, err := chain.GetBlock(lastHash)
+	Handle(err)
+	chain.setTip(&tip)
+	// and the address index, which every chain keeps from then on
+	if !chain.AddrIndexEnabled() {
+		chain.ReindexAddresses()
+	}
+
+	return chain
 }
 
 func InitBlockChain(address, nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
+	path := fmt.Sprintf(Params.DBPath, nodeId)
 	if DBexists(path) {
 		fmt.Println("Blockchain already exists")
 		runtime.Goexit()
 	}
 
+	genesis, err := Params.GenesisBlock(address)
+	Handle(err)
+	params, err := json.Marshal(Params)
+	Handle(err)
+
 	var lastHash []byte
 	opts := badger.DefaultOptions(path)
 	db, err := openDB(path, opts)
 	Handle(err)
 
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		fmt.Println("Genesis created")
+		fmt.Printf("Genesis %x created for chain %s\n", genesis.Hash, Params.ChainID)
+		err = txn.Set(paramsKey, params)
+		Handle(err)
 		err = txn.Set(genesis.Hash, genesis.Serialize())
 		Handle(err)
+		err = txn.Set(heightKey(genesis.Height), genesis.Hash)
+		Handle(err)
+		err = txn.Set(encodingKey, []byte{encodingVersion})
+		Handle(err)
 		err = txn.Set([]byte("lh"), genesis.Hash)
 		lastHash = genesis.Hash
 		return err
 	})
 	Handle(err)
 
-	return &BlockChain{lastHash, db}
+	chain := &BlockChain{LastHash: lastHash, Database: db, Engine: Params.Consensus.engine()}
+	chain.ReindexAddresses()
+	return chain
 }
 
-func (chain *BlockChain) AddBlock(block *Block) {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
-		}
-
-		err := txn.Set(block.Hash, block.Serialize())
-		Handle(err)
-
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		var lastHash []byte
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-		Handle(err)
-
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		var lastBlockData []byte
-		err = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
-		Handle(err)
-
-		lastBlock := Deserialize(lastBlockData)
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
-		}
-		return nil
-	})
-	Handle(err)
+// AddFileBlock mines the file transactions into a new block on the tip
+func (bc *BlockChain) AddFileBlock(txs ...*FileUploadTransaction) error {
+	return bc.addRecordsBlock(Records{FileTxs: txs})
 }
 
-func (bc *BlockChain) AddFileBlock(tx *FileUploadTransaction) error {
-
-	newBlock := NewFileBlock(tx, bc.LastHash, bc.GetBestHeight())
-
-	err := bc.Database.Update(func(txn *badger.Txn) error {
-		// Save the new block
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-
-		// Update last hash
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		Handle(err)
-
-		bc.LastHash = newBlock.Hash
-		return nil
-	})
-	Handle(err)
+func (bc *BlockChain) addRecordsBlock(records Records) error {
+	if err := records.Verify(); err != nil {
+		return err
+	}
+	_, err := bc.MineBlock(nil, records)
 	return err
 }
 
@@ -201,49 +180,62 @@ func (chain *BlockChain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
-	for _, tx := range transactions {
-		if !chain.VerifyTransaction(tx) {
-			log.Panic("Invalid Transaction")
-		}
-	}
+// MineBlock seals the transactions and records into a new block on the tip
+// with the chain's consensus engine
+func (chain *BlockChain) MineBlock(transactions []*Transaction, records Records) (*Block, error) {
+	return chain.MineBlockContext(context.Background(), transactions, records)
+}
 
-	var lastHash []byte
-	var lastHeight int
-	var lastBlockData []byte
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-		Handle(err)
+// MineBlockContext is MineBlock, giving up when ctx is cancelled
+func (chain *BlockChain) MineBlockContext(ctx context.Context, transactions []*Transaction, records Records) (*Block, error) {
+	tip, err := chain.Tip()
+	Handle(err)
+	return chain.MineBlockOn(ctx, &tip, transactions, records)
+}
 
-		item, err = txn.Get(lastHash)
-		Handle(err)
-		err = item.Value(func(val []byte) error {
-			lastBlockData = val
-			return nil
-		})
-		Handle(err)
+// Tip returns the block at the tip of the main chain. The tip cannot move
+// while it is read, so the block always matches LastHash.
+func (chain *BlockChain) Tip() (Block, error) {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+	return chain.GetBlock(chain.LastHash)
+}
 
-		lastBlock := Deserialize(lastBlockData)
-		lastHeight = lastBlock.Height
-		return nil
-	})
-	Handle(err)
+// MineBlockOn seals the transactions and records into a new block on
+// lastBlock, which need not be the tip anymore by the time it is added.
+// The transactions may spend each other; AddBlock checks every spend
+// against the branch of lastBlock.
+func (chain *BlockChain) MineBlockOn(ctx context.Context, lastBlock *Block, transactions []*Transaction, records Records) (*Block, error) {
+	if err := records.Verify(); err != nil {
+		log.Panic(err)
+	}
 
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		chain.LastHash = newBlock.Hash
-		return err
-	})
-	Handle(err)
-	return newBlock
+	// Clocks behind the chain's median time still produce a valid block
+	timestamp := time.Now().Unix()
+	if median := chain.MedianTimePast(lastBlock); timestamp <= median {
+		timestamp = median + 1
+	}
+	newBlock := &Block{
+		Timestamp:    timestamp,
+		Transactions: transactions,
+		Records:      records,
+		PrevHash:     lastBlock.Hash,
+		Height:       lastBlock.Height + 1,
+		Version:      BlockVersion,
+	}
+	engine := chain.engine()
+	if err := engine.Prepare(chain, lastBlock, newBlock); err != nil {
+		return nil, err
+	}
+	newBlock.MerkleRoot = newBlock.HashTransactions()
+	if err := engine.Seal(ctx, newBlock); err != nil {
+		return nil, err
+	}
+
+	if err := chain.AddBlock(newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
 }
 
 func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
@@ -254,17 +246,22 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 		block := iter.Next()
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
+			unspent := false
+			var outs TxOutputs
 		Outputs:
 			for outIdx, out := range tx.Outputs {
 				if spentTXOs[txID] != nil {
 					for _, spentOut := range spentTXOs[txID] {
 						if spentOut == outIdx {
+							outs.Outputs = append(outs.Outputs, TxOutput{})
 							continue Outputs
 						}
 					}
 				}
-				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				unspent = true
+			}
+			if unspent {
 				UTXO[txID] = outs
 			}
 			if !tx.IsCoinbase() {
@@ -281,20 +278,11 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 	return UTXO
 }
 
+// FindTransaction looks the transaction up in the transaction index when
+// it is enabled and walks the chain from the tip otherwise
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
-			}
-		}
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-	return Transaction{}, errors.New("Transaction does not exist")
+	tx, _, _, err := bc.GetTransaction(ID)
+	return tx, err
 }
 
 func (bc *BlockChain) SignTransaction(tx *Transaction, privKeyBytes []byte) {
@@ -0,0 +1,224 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+)
+
+const (
+	BlocklistAdd    = "add"
+	BlocklistRemove = "remove"
+)
+
+// BlocklistTransaction adds or removes a SHA-256 file hash on the
+// network-wide blocklist. It is signed by the wallet that proposed it, which
+// must be one of the blocklist signers in the chain params.
+type BlocklistTransaction struct {
+	Action    string
+	FileHash  string
+	Reason    string
+	PubKey    []byte
+	Signature []byte
+	Timestamp int64
+}
+
+// BlocklistEntry is the current state of a blocked hash as rebuilt from the chain
+type BlocklistEntry struct {
+	FileHash  string
+	Reason    string
+	AddedBy   string
+	Timestamp int64
+	BlockHash []byte
+}
+
+func NewBlocklistTransaction(w *wallet.Wallet, action, fileHash, reason string) (*BlocklistTransaction, error) {
+	if action != BlocklistAdd && action != BlocklistRemove {
+		return nil, fmt.Errorf("unknown blocklist action %q", action)
+	}
+	fileHash = strings.ToLower(fileHash)
+	if !IsFileHash(fileHash) {
+		return nil, errors.New("file hash must be a hex encoded SHA-256 digest")
+	}
+
+	tx := &BlocklistTransaction{
+		Action:    action,
+		FileHash:  fileHash,
+		Reason:    reason,
+		PubKey:    w.PublicKey,
+		Timestamp: time.Now().Unix(),
+	}
+	tx.Sign(*BytesToPrivateKey(w.PrivateKey))
+
+	return tx, nil
+}
+
+// IsFileHash reports whether s looks like a hex encoded SHA-256 digest
+func IsFileHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash covers every field except the signature
+func (tx *BlocklistTransaction) Hash() []byte {
+	txCopy := *tx
+	txCopy.Signature = nil
+
+	e := newEncoder()
+	e.blocklistTx(&txCopy)
+	hash := sha256.Sum256(e.buf)
+	return hash[:]
+}
+
+// legacyHash is Hash over gob, which older transactions were signed over
+// and version 0 blocks commit to
+func (tx *BlocklistTransaction) legacyHash() []byte {
+	txCopy := *tx
+	txCopy.Signature = nil
+
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(txCopy)
+	Handle(err)
+
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+func (tx *BlocklistTransaction) Sign(privKey ecdsa.PrivateKey) {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, tx.Hash())
+	if err != nil {
+		log.Panic(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	tx.Signature = signature
+}
+
+func (tx *BlocklistTransaction) Verify() bool {
+	if len(tx.Signature) == 0 || len(tx.PubKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(tx.Signature)
+	r.SetBytes(tx.Signature[:(sigLen / 2)])
+	s.SetBytes(tx.Signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(tx.PubKey)
+	x.SetBytes(tx.PubKey[:(keyLen / 2)])
+	y.SetBytes(tx.PubKey[(keyLen / 2):])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	return ecdsa.Verify(&rawPubKey, tx.Hash(), &r, &s) || ecdsa.Verify(&rawPubKey, tx.legacyHash(), &r, &s)
+}
+
+func (tx *BlocklistTransaction) Serialize() []byte {
+	e := newEncoder()
+	e.blocklistTx(tx)
+	return e.buf
+}
+
+// DecodeBlocklistTransaction reads a blocklist transaction in the canonical encoding
+func DecodeBlocklistTransaction(data []byte) (*BlocklistTransaction, error) {
+	d := newDecoder(data)
+	tx := d.blocklistTx()
+	return tx, d.finish()
+}
+
+// Authorised reports whether the signer may change the blocklist
+func (tx *BlocklistTransaction) Authorised() bool {
+	address := tx.Address()
+	for _, signer := range Params.BlocklistSigners {
+		if signer == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Address of the wallet that signed the transaction
+func (tx *BlocklistTransaction) Address() string {
+	w := wallet.Wallet{PublicKey: tx.PubKey}
+	return string(w.Address())
+}
+
+func (tx BlocklistTransaction) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("--- Blocklist %s %s:", tx.Action, tx.FileHash))
+	lines = append(lines, fmt.Sprintf("     Reason:    %s", tx.Reason))
+	lines = append(lines, fmt.Sprintf("     Signer:    %s", tx.Address()))
+	lines = append(lines, fmt.Sprintf("     Timestamp: %d", tx.Timestamp))
+	return strings.Join(lines, "\n")
+}
+
+func (bc *BlockChain) AddBlocklistBlock(txs ...*BlocklistTransaction) error {
+	return bc.addRecordsBlock(Records{BlocklistTxs: txs})
+}
+
+// Blocklist replays every blocklist transaction from genesis to the tip and
+// returns the hashes that are currently blocked. Transactions with a bad
+// signature or from a signer that is not authorised are ignored.
+func (bc *BlockChain) Blocklist() map[string]BlocklistEntry {
+	var blocks []*Block
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		if len(block.BlocklistTxs) > 0 {
+			blocks = append(blocks, block)
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	list := make(map[string]BlocklistEntry)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].BlocklistTxs {
+			if !tx.Verify() || !tx.Authorised() {
+				continue
+			}
+			switch tx.Action {
+			case BlocklistAdd:
+				list[tx.FileHash] = BlocklistEntry{
+					FileHash:  tx.FileHash,
+					Reason:    tx.Reason,
+					AddedBy:   tx.Address(),
+					Timestamp: tx.Timestamp,
+					BlockHash: blocks[i].Hash,
+				}
+			case BlocklistRemove:
+				delete(list, tx.FileHash)
+			}
+		}
+	}
+	return list
+}
+
+// IsBlocked looks up a hex encoded SHA-256 file hash on the blocklist
+func (bc *BlockChain) IsBlocked(fileHash string) (BlocklistEntry, bool) {
+	entry, ok := bc.Blocklist()[strings.ToLower(fileHash)]
+	return entry, ok
+}
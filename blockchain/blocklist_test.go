@@ -0,0 +1,31 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklistSigners(t *testing.T) {
+	defer UseParams(Params)
+	signer, other := wallet.MakeWallet(), wallet.MakeWallet()
+	params := Params
+	params.BlocklistSigners = []string{string(signer.Address())}
+	UseParams(params)
+
+	allowed, err := NewBlocklistTransaction(signer, BlocklistAdd, strings.Repeat("ab", 32), "ransomware")
+	assert.NoError(t, err)
+	assert.NoError(t, Records{BlocklistTxs: []*BlocklistTransaction{allowed}}.Verify())
+
+	// A valid signature is not enough, the signer must be listed
+	denied, err := NewBlocklistTransaction(other, BlocklistAdd, strings.Repeat("cd", 32), "spam")
+	assert.NoError(t, err)
+	assert.True(t, denied.Verify())
+	assert.Error(t, Records{BlocklistTxs: []*BlocklistTransaction{denied}}.Verify())
+
+	decoded, err := DecodeBlocklistTransaction(allowed.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, allowed, decoded)
+}
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ConsensusEngine decides who may extend the chain and how branches are
+// weighed against each other. BlockChain uses proof-of-work unless another
+// engine is set.
+type ConsensusEngine interface {
+	// Prepare fills in the consensus fields of a new block on top of parent
+	Prepare(chain *BlockChain, parent, block *Block) error
+	// Seal finalises a prepared block, setting its Hash. It gives up with
+	// the context's error when ctx is cancelled.
+	Seal(ctx context.Context, block *Block) error
+	// CheckHeader checks what the seal proves on its own: the hash is the
+	// header's and the seal is well formed. It runs before a block is stored.
+	CheckHeader(block *Block) error
+	// VerifySeal checks the consensus fields and seal of a block whose
+	// parent is known
+	VerifySeal(chain *BlockChain, parent, block *Block) error
+	// Weight is the block's contribution to its branch's fork-choice score
+	Weight(block *Block) *big.Int
+}
+
+// ProofOfWorkEngine seals blocks with a hash below the retargeted target and
+// weighs branches by the work they took
+type ProofOfWorkEngine struct{}
+
+func (ProofOfWorkEngine) Prepare(chain *BlockChain, parent, block *Block) error {
+	block.Bits = chain.NextBits(parent)
+	return nil
+}
+
+func (ProofOfWorkEngine) Seal(ctx context.Context, block *Block) error {
+	pow := NewProof(block)
+	nonce, hash, stats, err := pow.Mine(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mined %x in %s at %.0f hashes/s\n", hash, stats.Elapsed.Round(time.Millisecond), stats.Hashrate())
+	block.Hash = hash
+	block.Nonce = nonce
+	return nil
+}
+
+func (ProofOfWorkEngine) CheckHeader(block *Block) error {
+	// Version 0 headers predate difficulty bits and do not seal them,
+	// while current headers must carry them
+	if block.Version == 0 && block.Bits != 0 {
+		return reject(block, RejectDifficulty, "version 0 block with difficulty bits")
+	}
+	if block.Version > 0 && block.Bits == 0 {
+		return reject(block, RejectDifficulty, "missing difficulty bits")
+	}
+	pow := NewProof(block)
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(PowLimit) > 0 {
+		return reject(block, RejectDifficulty, "bits %08x are outside the allowed targets", EffectiveBits(block))
+	}
+	hash := sha256.Sum256(pow.InitData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return reject(block, RejectHash, "hash does not match the header")
+	}
+	if !pow.Validate() {
+		return reject(block, RejectProofOfWork, "hash is above the target")
+	}
+	return nil
+}
+
+func (e ProofOfWorkEngine) VerifySeal(chain *BlockChain, parent, block *Block) error {
+	if err := e.CheckHeader(block); err != nil {
+		return err
+	}
+	if bits := chain.NextBits(parent); EffectiveBits(block) != bits {
+		return reject(block, RejectDifficulty, "bits %08x, expected %08x", EffectiveBits(block), bits)
+	}
+	return nil
+}
+
+// Weight is the expected number of hashes needed to mine the block
+func (ProofOfWorkEngine) Weight(block *Block) *big.Int {
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, CompactToBig(EffectiveBits(block)))
+}
+
+func (chain *BlockChain) engine() ConsensusEngine {
+	if chain.Engine == nil {
+		return ProofOfWorkEngine{}
+	}
+	return chain.Engine
+}
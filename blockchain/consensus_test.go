@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+// instantEngine seals blocks without any work, for tests
+type instantEngine struct{}
+
+func (instantEngine) Prepare(chain *BlockChain, parent, block *Block) error { return nil }
+
+func (instantEngine) Seal(ctx context.Context, block *Block) error {
+	hash := sha256.Sum256(append(append([]byte{}, block.PrevHash...), block.MerkleRoot...))
+	block.Hash = hash[:]
+	return nil
+}
+
+func (e instantEngine) CheckHeader(block *Block) error {
+	sealed := *block
+	e.Seal(context.Background(), &sealed)
+	if !bytes.Equal(sealed.Hash, block.Hash) {
+		return errors.New("bad seal")
+	}
+	return nil
+}
+
+func (e instantEngine) VerifySeal(chain *BlockChain, parent, block *Block) error {
+	return e.CheckHeader(block)
+}
+
+func (instantEngine) Weight(block *Block) *big.Int { return big.NewInt(1) }
+
+func TestConsensusEngine(t *testing.T) {
+	chain, address := newTestChain(t)
+	chain.Engine = instantEngine{}
+
+	block, err := chain.MineBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{})
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, chain.LastHash)
+	assert.Equal(t, 1, chain.GetBestHeight())
+
+	forged := *block
+	forged.Height = 1
+	forged.Transactions = []*Transaction{CoinbaseTx(address, "", 1, 0)}
+	forged.MerkleRoot = forged.HashTransactions()
+	forged.Hash = []byte("forged")
+	assert.Error(t, chain.AddBlock(&forged))
+}
+
+func TestMineBlockOn(t *testing.T) {
+	chain, address := newTestChain(t)
+	chain.Engine = instantEngine{}
+
+	// A block mined on a tip that moved meanwhile extends that tip, at the
+	// height its coinbase was built for
+	tip, err := chain.Tip()
+	assert.NoError(t, err)
+	_, err = chain.MineBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{})
+	assert.NoError(t, err)
+	block, err := chain.MineBlockOn(context.Background(), &tip, []*Transaction{CoinbaseTx(address, "late", tip.Height+1, 0)}, Records{})
+	assert.NoError(t, err)
+	assert.Equal(t, tip.Hash, block.PrevHash)
+	assert.Equal(t, 1, block.Height)
+	assert.NotEqual(t, block.Hash, chain.LastHash)
+}
+
+func TestMinePoolChain(t *testing.T) {
+	chain, address := newTestChain(t)
+	chain.Engine = instantEngine{}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	a, b := wallet.MakeWallet(), wallet.MakeWallet()
+	_, err := chain.MineBlock([]*Transaction{CoinbaseTx(string(a.Address()), "", 1, 0)}, Records{})
+	assert.NoError(t, err)
+
+	// The child spends the parent while both still wait in the pool
+	parent := NewTransaction(a, string(b.Address()), 5, 1, &UTXOSet)
+	child := &Transaction{
+		Inputs:  []TxInput{{ID: parent.ID, Out: 0, PubKey: b.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(4, address)},
+	}
+	child.ID = child.Hash()
+	child.Sign(*BytesToPrivateKey(b.PrivateKey), map[string]Transaction{hex.EncodeToString(parent.ID): *parent})
+
+	tip, err := chain.Tip()
+	assert.NoError(t, err)
+	txs, fees, invalid := chain.BlockTemplateOn(tip.Hash, []*Transaction{child, parent})
+	assert.Equal(t, []*Transaction{parent, child}, txs)
+	assert.Equal(t, 2, fees)
+	assert.Empty(t, invalid)
+
+	coinbase := CoinbaseTx(address, "", tip.Height+1, fees)
+	block, err := chain.MineBlockOn(context.Background(), &tip, append([]*Transaction{coinbase}, txs...), Records{})
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, chain.LastHash)
+}
+
+func TestMineCancel(t *testing.T) {
+	block := &Block{Timestamp: 1, PrevHash: []byte("parent"), Bits: LegacyBits}
+	pow := NewProof(block)
+	nonce, hash, stats, err := pow.Mine(context.Background())
+	assert.NoError(t, err)
+	block.Nonce = nonce
+	want := sha256.Sum256(pow.InitData(nonce))
+	assert.Equal(t, want[:], hash)
+	assert.True(t, pow.Validate())
+	assert.NotZero(t, stats.Hashes)
+
+	// No hash is below a zero target, only cancelling ends the search
+	pow.Target = big.NewInt(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, _, err = pow.Mine(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHeaderCommitments(t *testing.T) {
+	chain, address := newTestChain(t)
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, ProofOfWorkEngine{}.CheckHeader(block))
+
+	// The seal covers the version, height and timestamp
+	for _, change := range []func(b *Block){
+		func(b *Block) { b.Version++ },
+		func(b *Block) { b.Height++ },
+		func(b *Block) { b.Timestamp++ },
+	} {
+		changed := *block
+		change(&changed)
+		assert.Error(t, ProofOfWorkEngine{}.CheckHeader(&changed))
+	}
+
+	// and current blocks must carry their bits, or the version 0 header
+	// would leave the timestamp out
+	unbits := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, 0)
+	err := chain.AddBlock(unbits)
+	assert.Equal(t, RejectDifficulty, err.(*BlockError).Reason)
+
+	// Validators sign the version as well
+	signed := *block
+	signed.Signer = []byte("validator")
+	hash := sealHash(&signed)
+	signed.Version--
+	assert.NotEqual(t, hash, sealHash(&signed))
+}
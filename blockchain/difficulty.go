@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+var (
+	// LegacyBits encodes the fixed Difficulty as a compact target
+	LegacyBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-Difficulty)))
+	// PowLimit is the easiest target retargeting may reach
+	PowLimit = new(big.Int).Lsh(big.NewInt(1), 256-8)
+)
+
+// maxAdjust bounds how far a single retarget can move the target
+const maxAdjust = 4
+
+// EffectiveBits is the compact target the block was mined against
+func EffectiveBits(block *Block) uint32 {
+	if block.Bits == 0 {
+		return LegacyBits
+	}
+	return block.Bits
+}
+
+// CompactToBig expands a compact target: the top byte is the length of
+// the number in bytes and the low three bytes its most significant digits
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+	target := big.NewInt(mantissa)
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact encodes a target in compact form, dropping the digits that
+// do not fit in the mantissa
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint(len(target.Bytes()))
+	var mantissa uint64
+	if exponent <= 3 {
+		mantissa = target.Uint64() << (8 * (3 - exponent))
+	} else {
+		mantissa = new(big.Int).Rsh(target, 8*(exponent-3)).Uint64()
+	}
+
+	// The high mantissa bit is a sign bit, so shift it out of the way
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | uint32(mantissa)
+}
+
+// NextBits is the difficulty a block on top of parent must carry. It stays
+// the same within a retarget window and is then scaled by how far the
+// window's actual duration was from Params.RetargetInterval * Params.BlockInterval.
+func (chain *BlockChain) NextBits(parent *Block) uint32 {
+	bits := EffectiveBits(parent)
+	height := parent.Height + 1
+	interval := Params.RetargetInterval
+	if height%interval != 0 {
+		return bits
+	}
+
+	first := parent
+	for i := 1; i < interval && len(first.PrevHash) > 0; i++ {
+		first = chain.parent(first)
+	}
+
+	expected := int64(interval-1) * Params.BlockInterval
+	actual := parent.Timestamp - first.Timestamp
+	if actual < expected/maxAdjust {
+		actual = expected / maxAdjust
+	}
+	if actual > expected*maxAdjust {
+		actual = expected * maxAdjust
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(PowLimit) > 0 {
+		target.Set(PowLimit)
+	}
+	return BigToCompact(target)
+}
@@ -0,0 +1,41 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactTarget(t *testing.T) {
+	assert.Equal(t, uint32(0x1f100000), LegacyBits)
+	for _, bits := range []uint32{0x1d00ffff, 0x1f100000, 0x1e7fffff, 0x03123456} {
+		assert.Equal(t, bits, BigToCompact(CompactToBig(bits)))
+	}
+	assert.Equal(t, big.NewInt(4096), ProofOfWorkEngine{}.Weight(&Block{}))
+}
+
+func TestRetarget(t *testing.T) {
+	defer func(interval int) { Params.RetargetInterval = interval }(Params.RetargetInterval)
+	Params.RetargetInterval = 2
+
+	chain, address := newTestChain(t)
+	genesis, err := chain.GetBlock(chain.LastHash)
+	assert.NoError(t, err)
+
+	// Blocks mined within the same second are as fast as it gets, so the
+	// target shrinks by the maximum adjustment
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, genesis.Hash, 1, chain.NextBits(&genesis))
+	block.Timestamp = genesis.Timestamp
+	assert.Equal(t, LegacyBits, block.Bits)
+
+	bits := chain.NextBits(block)
+	expected := new(big.Int).Div(CompactToBig(LegacyBits), big.NewInt(maxAdjust))
+	assert.Equal(t, expected, CompactToBig(bits))
+
+	block = createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, genesis.Hash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(block))
+	wrong := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 0)}, Records{}, chain.LastHash, 2, LegacyBits)
+	err = chain.AddBlock(wrong)
+	assert.Equal(t, RejectDifficulty, err.(*BlockError).Reason)
+}
@@ -0,0 +1,432 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The canonical encoding is what blocks and transactions are hashed,
+// stored and sent as. Every value starts with one version byte, then the
+// fields follow in declaration order:
+//
+//	int, int64      8 bytes, big-endian two's complement
+//	uint32          4 bytes, big-endian
+//	float64         8 bytes, big-endian IEEE 754 bits
+//	bool, pointer   1 byte, 0 or 1 (a pointer is followed by its value if 1)
+//	[]byte, string  4 byte big-endian length, then the bytes
+//	slices          4 byte big-endian count, then each element
+//
+// Every value has exactly one encoding. Decoders reject unknown versions,
+// truncated input, flag bytes other than 0 or 1 and trailing bytes.
+const encodingVersion byte = 1
+
+// BlockVersion is the version new blocks are created with. Version 0 blocks
+// predate it: they hash their contents with gob, are not held to the median
+// time past, carry unsigned file transactions and seal a header without
+// their version, height and timestamp.
+const BlockVersion = 1
+
+var errNonCanonical = errors.New("non-canonical encoding")
+
+// encoder writes the canonical encoding
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder() *encoder {
+	return &encoder{buf: []byte{encodingVersion}}
+}
+
+func (e *encoder) uint32(v uint32) { e.buf = binary.BigEndian.AppendUint32(e.buf, v) }
+func (e *encoder) int64(v int64)   { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *encoder) int(v int)       { e.int64(int64(v)) }
+
+func (e *encoder) float64(v float64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) { e.bytes([]byte(v)) }
+
+func (e *encoder) count(n int) { e.uint32(uint32(n)) }
+
+// decoder reads the canonical encoding. The first error sticks and every
+// later read returns a zero value.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	d := &decoder{buf: data}
+	if len(data) == 0 || data[0] != encodingVersion {
+		d.fail("unknown encoding version")
+		return d
+	}
+	d.buf = data[1:]
+	return d
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", errNonCanonical, fmt.Sprintf(format, args...))
+	}
+	d.buf = nil
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.fail("truncated input")
+		return nil
+	}
+	v := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if v := d.take(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *decoder) int() int { return int(d.int64()) }
+
+func (d *decoder) float64() float64 {
+	if v := d.take(8); v != nil {
+		return math.Float64frombits(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *decoder) bool() bool {
+	v := d.take(1)
+	if v == nil {
+		return false
+	}
+	if v[0] > 1 {
+		d.fail("flag byte %d", v[0])
+	}
+	return v[0] == 1
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	v := d.take(int(n))
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+func (d *decoder) string() string { return string(d.take(int(d.uint32()))) }
+
+// count reads a slice length. Every element takes at least minSize bytes,
+// so a count the remaining input cannot hold is rejected before allocating.
+func (d *decoder) count(minSize int) int {
+	n := int(d.uint32())
+	if d.err == nil && n*minSize > len(d.buf) {
+		d.fail("count %d exceeds input", n)
+		return 0
+	}
+	return n
+}
+
+// finish reports the first error, or trailing bytes after the value
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.fail("%d trailing bytes", len(d.buf))
+	}
+	return d.err
+}
+
+func (e *encoder) input(in TxInput) {
+	e.bytes(in.ID)
+	e.int(in.Out)
+	e.bytes(in.Signature)
+	e.bytes(in.PubKey)
+}
+
+func (d *decoder) input() TxInput {
+	return TxInput{ID: d.bytes(), Out: d.int(), Signature: d.bytes(), PubKey: d.bytes()}
+}
+
+func (e *encoder) output(out TxOutput) {
+	e.int(out.Value)
+	e.bytes(out.PubKeyHash)
+}
+
+func (d *decoder) output() TxOutput {
+	return TxOutput{Value: d.int(), PubKeyHash: d.bytes()}
+}
+
+func (e *encoder) outputs(outs []TxOutput) {
+	e.count(len(outs))
+	for _, out := range outs {
+		e.output(out)
+	}
+}
+
+func (d *decoder) outputs() []TxOutput {
+	var outs []TxOutput
+	for i, n := 0, d.count(12); i < n; i++ {
+		outs = append(outs, d.output())
+	}
+	return outs
+}
+
+func (e *encoder) transaction(tx *Transaction) {
+	e.bytes(tx.ID)
+	e.count(len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		e.input(in)
+	}
+	e.outputs(tx.Outputs)
+}
+
+func (d *decoder) transaction() *Transaction {
+	tx := &Transaction{ID: d.bytes()}
+	for i, n := 0, d.count(20); i < n; i++ {
+		tx.Inputs = append(tx.Inputs, d.input())
+	}
+	tx.Outputs = d.outputs()
+	return tx
+}
+
+func (e *encoder) scan(s ScanResult) {
+	e.string(s.Verdict)
+	e.count(len(s.Detectors))
+	for _, r := range s.Detectors {
+		e.string(r.Detector)
+		e.string(r.Version)
+		e.float64(r.Score)
+		e.float64(r.Threshold)
+		e.count(len(r.MatchedRules))
+		for _, rule := range r.MatchedRules {
+			e.string(rule)
+		}
+		e.bool(r.Flagged)
+	}
+	e.int64(s.ScannedAt)
+}
+
+func (d *decoder) scan() ScanResult {
+	s := ScanResult{Verdict: d.string()}
+	for i, n := 0, d.count(29); i < n; i++ {
+		r := DetectorResult{Detector: d.string(), Version: d.string(), Score: d.float64(), Threshold: d.float64()}
+		for j, m := 0, d.count(4); j < m; j++ {
+			r.MatchedRules = append(r.MatchedRules, d.string())
+		}
+		r.Flagged = d.bool()
+		s.Detectors = append(s.Detectors, r)
+	}
+	s.ScannedAt = d.int64()
+	return s
+}
+
+func (e *encoder) fileTx(tx *FileUploadTransaction) {
+	e.string(tx.FromAddress)
+	e.string(tx.Filename)
+	e.string(tx.FileHash)
+	e.string(tx.FilePath)
+	e.int64(tx.Size)
+	e.int64(tx.Timestamp)
+	e.scan(tx.Scan)
+	e.bool(tx.Erasure != nil)
+	if tx.Erasure != nil {
+		e.int(tx.Erasure.DataShards)
+		e.int(tx.Erasure.ParityShards)
+		e.int64(tx.Erasure.Size)
+		e.count(len(tx.Erasure.Shards))
+		for _, shard := range tx.Erasure.Shards {
+			e.int(shard.Index)
+			e.string(shard.Hash)
+			e.string(shard.Node)
+			e.int64(shard.Size)
+			e.bytes(shard.ChunkRoot)
+		}
+	}
+	e.bytes(tx.ChunkRoot)
+	e.bytes(tx.PubKey)
+	e.bytes(tx.Signature)
+}
+
+func (d *decoder) fileTx() *FileUploadTransaction {
+	tx := &FileUploadTransaction{
+		FromAddress: d.string(),
+		Filename:    d.string(),
+		FileHash:    d.string(),
+		FilePath:    d.string(),
+		Size:        d.int64(),
+		Timestamp:   d.int64(),
+		Scan:        d.scan(),
+	}
+	if d.bool() {
+		tx.Erasure = &ErasureInfo{DataShards: d.int(), ParityShards: d.int(), Size: d.int64()}
+		for i, n := 0, d.count(28); i < n; i++ {
+			tx.Erasure.Shards = append(tx.Erasure.Shards, ShardInfo{
+				Index:     d.int(),
+				Hash:      d.string(),
+				Node:      d.string(),
+				Size:      d.int64(),
+				ChunkRoot: d.bytes(),
+			})
+		}
+	}
+	tx.ChunkRoot = d.bytes()
+	tx.PubKey = d.bytes()
+	tx.Signature = d.bytes()
+	return tx
+}
+
+func (e *encoder) blocklistTx(tx *BlocklistTransaction) {
+	e.string(tx.Action)
+	e.string(tx.FileHash)
+	e.string(tx.Reason)
+	e.bytes(tx.PubKey)
+	e.bytes(tx.Signature)
+	e.int64(tx.Timestamp)
+}
+
+func (d *decoder) blocklistTx() *BlocklistTransaction {
+	return &BlocklistTransaction{
+		Action:    d.string(),
+		FileHash:  d.string(),
+		Reason:    d.string(),
+		PubKey:    d.bytes(),
+		Signature: d.bytes(),
+		Timestamp: d.int64(),
+	}
+}
+
+func (e *encoder) snapshotTx(tx *SnapshotTransaction) {
+	e.string(tx.FromAddress)
+	e.string(tx.Dir)
+	e.bytes(tx.Root)
+	e.count(len(tx.Manifest))
+	for _, entry := range tx.Manifest {
+		e.string(entry.Path)
+		e.string(entry.FileHash)
+		e.int64(entry.Size)
+		e.uint32(entry.Mode)
+	}
+	e.int64(tx.Timestamp)
+}
+
+func (d *decoder) snapshotTx() *SnapshotTransaction {
+	tx := &SnapshotTransaction{FromAddress: d.string(), Dir: d.string(), Root: d.bytes()}
+	for i, n := 0, d.count(20); i < n; i++ {
+		tx.Manifest = append(tx.Manifest, SnapshotEntry{
+			Path:     d.string(),
+			FileHash: d.string(),
+			Size:     d.int64(),
+			Mode:     d.uint32(),
+		})
+	}
+	tx.Timestamp = d.int64()
+	return tx
+}
+
+func (e *encoder) vote(vote *ValidatorVote) {
+	e.string(vote.Action)
+	e.string(vote.Address)
+	e.bytes(vote.PubKey)
+	e.bytes(vote.Signature)
+	e.int64(vote.Timestamp)
+	e.int(vote.Epoch)
+}
+
+func (d *decoder) vote() *ValidatorVote {
+	return &ValidatorVote{
+		Action:    d.string(),
+		Address:   d.string(),
+		PubKey:    d.bytes(),
+		Signature: d.bytes(),
+		Timestamp: d.int64(),
+		Epoch:     d.int(),
+	}
+}
+
+func (e *encoder) block(b *Block) {
+	e.int(b.Version)
+	e.int64(b.Timestamp)
+	e.bytes(b.Hash)
+	e.bytes(b.MerkleRoot)
+	e.count(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		e.transaction(tx)
+	}
+	e.count(len(b.FileTxs))
+	for _, tx := range b.FileTxs {
+		e.fileTx(tx)
+	}
+	e.count(len(b.BlocklistTxs))
+	for _, tx := range b.BlocklistTxs {
+		e.blocklistTx(tx)
+	}
+	e.count(len(b.SnapshotTxs))
+	for _, tx := range b.SnapshotTxs {
+		e.snapshotTx(tx)
+	}
+	e.count(len(b.VoteTxs))
+	for _, vote := range b.VoteTxs {
+		e.vote(vote)
+	}
+	e.bytes(b.PrevHash)
+	e.int(b.Nonce)
+	e.int(b.Height)
+	e.uint32(b.Bits)
+	e.bytes(b.Signer)
+	e.bytes(b.Signature)
+}
+
+func (d *decoder) block() *Block {
+	b := &Block{Version: d.int(), Timestamp: d.int64(), Hash: d.bytes(), MerkleRoot: d.bytes()}
+	for i, n := 0, d.count(12); i < n; i++ {
+		b.Transactions = append(b.Transactions, d.transaction())
+	}
+	for i, n := 0, d.count(50); i < n; i++ {
+		b.FileTxs = append(b.FileTxs, d.fileTx())
+	}
+	for i, n := 0, d.count(28); i < n; i++ {
+		b.BlocklistTxs = append(b.BlocklistTxs, d.blocklistTx())
+	}
+	for i, n := 0, d.count(24); i < n; i++ {
+		b.SnapshotTxs = append(b.SnapshotTxs, d.snapshotTx())
+	}
+	for i, n := 0, d.count(24); i < n; i++ {
+		b.VoteTxs = append(b.VoteTxs, d.vote())
+	}
+	b.PrevHash = d.bytes()
+	b.Nonce = d.int()
+	b.Height = d.int()
+	b.Bits = d.uint32()
+	b.Signer = d.bytes()
+	b.Signature = d.bytes()
+	return b
+}
@@ -0,0 +1,176 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalEncoding(t *testing.T) {
+	w := wallet.MakeWallet()
+	blocklistTx, err := NewBlocklistTransaction(w, BlocklistAdd, strings.Repeat("ab", 32), "test")
+	assert.NoError(t, err)
+	vote, err := NewValidatorVote(w, VoteAdd, string(w.Address()), 0)
+	assert.NoError(t, err)
+
+	block := &Block{
+		Version:      BlockVersion,
+		Timestamp:    1700000000,
+		Transactions: []*Transaction{CoinbaseTx(string(w.Address()), "", 1, 0)},
+		Records: Records{
+			FileTxs: []*FileUploadTransaction{{
+				Filename: "a.txt",
+				Scan:     ScanResult{Verdict: VerdictClean, Detectors: []DetectorResult{{Detector: "entropy", Score: 0.5}}},
+				Erasure:  &ErasureInfo{DataShards: 2, ParityShards: 1, Shards: []ShardInfo{{Index: 0, Hash: "h"}}},
+			}},
+			BlocklistTxs: []*BlocklistTransaction{blocklistTx},
+			SnapshotTxs:  []*SnapshotTransaction{{Dir: "/d", Manifest: []SnapshotEntry{{Path: "a", Mode: 0644}}}},
+			VoteTxs:      []*ValidatorVote{vote},
+		},
+		PrevHash: []byte("parent"),
+		Height:   7,
+		Bits:     LegacyBits,
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	data := block.Serialize()
+	decoded, err := DecodeBlock(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded.Serialize())
+	assert.Equal(t, block.MerkleRoot, decoded.HashTransactions())
+	assert.True(t, decoded.BlocklistTxs[0].Verify())
+	assert.True(t, decoded.VoteTxs[0].Verify())
+
+	_, err = DecodeBlock(append(data, 0))
+	assert.Error(t, err, "trailing bytes")
+	_, err = DecodeBlock(data[:len(data)-1])
+	assert.Error(t, err, "truncated")
+	_, err = DecodeBlock(append([]byte{encodingVersion + 1}, data[1:]...))
+	assert.Error(t, err, "unknown version")
+
+	// The erasure presence flag follows the fixed size fields of an empty file tx
+	fileData := FileUploadTransaction{}.Serialize()
+	fileData[49] = 2
+	_, err = DecodeFileTransaction(fileData)
+	assert.Error(t, err, "flag byte")
+}
+
+func TestSignedFileTransaction(t *testing.T) {
+	w := wallet.MakeWallet()
+	tx := NewFileUploadTransaction(string(w.Address()), "a.txt", []byte("data"), "", ScanResult{Verdict: VerdictMalicious})
+	records := Records{FileTxs: []*FileUploadTransaction{tx}}
+	assert.Error(t, records.Verify(), "unsigned")
+	assert.NoError(t, records.verify(0), "version 0 blocks carry unsigned file transactions")
+
+	tx.Sign(w)
+	assert.NoError(t, records.Verify())
+	decoded, err := DecodeFileTransaction(tx.Serialize())
+	assert.NoError(t, err)
+	assert.True(t, decoded.Verify())
+
+	// Neither the verdict nor the uploader can be changed after signing
+	decoded.Scan.Verdict = VerdictClean
+	assert.False(t, decoded.Verify())
+	forged := *tx
+	forged.FromAddress = string(wallet.MakeWallet().Address())
+	assert.False(t, forged.Verify())
+}
+
+func TestMigrateEncoding(t *testing.T) {
+	chain, address := newTestChain(t)
+
+	// A version 0 block identifies its coinbase and builds its root with gob
+	coinbase := CoinbaseTx(address, "", 1, 0)
+	coinbase.ID = coinbase.legacyHash()
+	block := &Block{Timestamp: 1700000000, Transactions: []*Transaction{coinbase}, PrevHash: chain.LastHash, Height: 1}
+	block.MerkleRoot = block.HashTransactions()
+	assert.NoError(t, ProofOfWorkEngine{}.Seal(context.Background(), block))
+
+	// Legacy chains never had newer blocks below a version 0 one, so it
+	// goes in as the tip without validation against the test genesis
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(block.Hash, block.Serialize())
+	})
+	assert.NoError(t, err)
+	chain.setTip(block)
+
+	var legacy bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&legacy).Encode(block))
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(block.Hash, legacy.Bytes())
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, chain.MigrateEncoding())
+	assert.True(t, chain.encodingMigrated())
+	stored, err := chain.GetBlock(block.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stored.Version)
+	assert.True(t, NewProof(&stored).Validate())
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	assert.Len(t, UTXOSet.FindUnspentTransactions(coinbase.Outputs[0].PubKeyHash), 2)
+	assert.Equal(t, 0, chain.MigrateEncoding())
+}
+
+func TestMigrateFileBlock(t *testing.T) {
+	chain, address := newTestChain(t)
+
+	// Blocks written before records were grouped held a single FileTx
+	type baselineFileTx struct {
+		FromAddress string
+		Filename    string
+		FileHash    string
+		FilePath    string
+		Timestamp   int64
+	}
+	type baselineBlock struct {
+		Timestamp    int64
+		Hash         []byte
+		Transactions []*Transaction
+		FileTx       *baselineFileTx
+		PrevHash     []byte
+		Nonce        int
+		Height       int
+	}
+	fileHash := strings.Repeat("ab", 32)
+	block := &Block{
+		Timestamp: 1700000000,
+		Hash:      bytes.Repeat([]byte{7}, 32),
+		Records:   Records{FileTxs: []*FileUploadTransaction{{FromAddress: address, Filename: "report.pdf", FileHash: fileHash, Timestamp: 1700000000}}},
+		PrevHash:  chain.LastHash,
+		Height:    1,
+	}
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(block.Hash, block.Serialize())
+	})
+	assert.NoError(t, err)
+	chain.setTip(block)
+
+	var legacy bytes.Buffer
+	err = gob.NewEncoder(&legacy).Encode(baselineBlock{
+		Timestamp: block.Timestamp,
+		Hash:      block.Hash,
+		FileTx:    &baselineFileTx{FromAddress: address, Filename: "report.pdf", FileHash: fileHash, Timestamp: 1700000000},
+		PrevHash:  block.PrevHash,
+		Height:    block.Height,
+	})
+	assert.NoError(t, err)
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(block.Hash, legacy.Bytes())
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, chain.MigrateEncoding())
+	stored, err := chain.GetBlock(block.Hash)
+	assert.NoError(t, err)
+	assert.Len(t, stored.FileTxs, 1)
+	assert.Equal(t, "report.pdf", stored.FileTxs[0].Filename)
+	assert.Contains(t, chain.Files(), fileHash)
+}
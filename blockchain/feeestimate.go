@@ -0,0 +1,211 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	// MaxFeeTarget is the most blocks a fee can be estimated for
+	MaxFeeTarget = 25
+	// DefaultFeeTarget is the confirmation target the wallet pays for
+	DefaultFeeTarget = 6
+
+	feeBuckets = 24    // Rate 0, then doubling ranges from 1 per 1000 bytes
+	feeDecay   = 0.998 // Weight kept by older samples each block
+	feeSuccess = 0.85  // Share of a bucket that must confirm in time
+	feeSamples = 2     // Weighted samples a bucket needs to be trusted
+)
+
+var (
+	feeStatsKey = []byte("feestats")
+
+	// ErrNoFeeEstimate is returned until enough transactions have confirmed
+	ErrNoFeeEstimate = errors.New("not enough confirmed transactions to estimate a fee")
+)
+
+// FeeStats are the confirmation times seen per fee rate bucket, with older
+// blocks weighing less
+type FeeStats struct {
+	Confirmed [feeBuckets][MaxFeeTarget]float64 // Confirmed within i+1 blocks
+	Total     [feeBuckets]float64               // Confirmed or given up on
+	Height    int                               // Last block counted
+}
+
+type trackedTx struct {
+	bucket int
+	height int
+}
+
+// FeeEstimator watches the memory pool and the blocks that follow to learn
+// how long each fee rate takes to confirm. Its stats are kept in the
+// database so estimatefee can read them without a running node.
+type FeeEstimator struct {
+	chain   *BlockChain
+	mu      sync.Mutex
+	stats   FeeStats
+	pending map[string]trackedTx
+}
+
+func NewFeeEstimator(chain *BlockChain) *FeeEstimator {
+	return &FeeEstimator{
+		chain:   chain,
+		stats:   chain.feeStats(),
+		pending: make(map[string]trackedTx),
+	}
+}
+
+// feeBucket is the bucket of a rate per 1000 bytes
+func feeBucket(rate int) int {
+	bucket := 0
+	for rate > 0 && bucket < feeBuckets-1 {
+		bucket++
+		rate >>= 1
+	}
+	return bucket
+}
+
+// bucketRate is the lowest rate in a bucket
+func bucketRate(bucket int) int {
+	if bucket == 0 {
+		return 0
+	}
+	return 1 << uint(bucket-1)
+}
+
+// Track starts timing a transaction that entered the memory pool.
+// Transactions spending other pool transactions have no known fee yet and
+// are left out.
+func (e *FeeEstimator) Track(tx *Transaction) {
+	if e == nil {
+		return
+	}
+	fee, err := UTXOSet{Blockchain: e.chain}.Fee(tx)
+	if err != nil {
+		return
+	}
+	height := e.chain.GetBestHeight()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := e.pending[id]; !ok {
+		e.pending[id] = trackedTx{feeBucket(FeeRate(fee, tx.Size())), height}
+	}
+}
+
+// ProcessBlock counts the tracked transactions the new tip confirmed and
+// gives up on those waiting longer than MaxFeeTarget blocks
+func (e *FeeEstimator) ProcessBlock(block *Block) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// A block at a height already counted comes from a reorganization
+	counted := block.Height > e.stats.Height
+	if counted {
+		for b := range e.stats.Total {
+			e.stats.Total[b] *= feeDecay
+			for i := range e.stats.Confirmed[b] {
+				e.stats.Confirmed[b][i] *= feeDecay
+			}
+		}
+		e.stats.Height = block.Height
+	}
+
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		tracked, ok := e.pending[id]
+		if !ok {
+			continue
+		}
+		delete(e.pending, id)
+		if !counted {
+			continue
+		}
+		e.stats.Total[tracked.bucket]++
+		waited := block.Height - tracked.height
+		if waited < 1 {
+			waited = 1
+		}
+		for i := waited - 1; i < MaxFeeTarget; i++ {
+			e.stats.Confirmed[tracked.bucket][i]++
+		}
+	}
+	for id, tracked := range e.pending {
+		if block.Height-tracked.height > MaxFeeTarget {
+			e.stats.Total[tracked.bucket]++
+			delete(e.pending, id)
+		}
+	}
+
+	if counted {
+		e.chain.storeFeeStats(e.stats)
+	}
+}
+
+// EstimateFee is the fee rate per 1000 bytes that has confirmed within
+// blocks blocks: the lowest bucket from which every bucket with enough
+// samples above it confirmed often enough
+func (stats FeeStats) EstimateFee(blocks int) (int, error) {
+	if blocks < 1 {
+		blocks = 1
+	}
+	if blocks > MaxFeeTarget {
+		blocks = MaxFeeTarget
+	}
+
+	best := -1
+	for b := feeBuckets - 1; b >= 0; b-- {
+		if stats.Total[b] < feeSamples {
+			continue
+		}
+		if stats.Confirmed[b][blocks-1]/stats.Total[b] < feeSuccess {
+			break
+		}
+		best = b
+	}
+	if best < 0 {
+		return 0, ErrNoFeeEstimate
+	}
+	return bucketRate(best), nil
+}
+
+// EstimateFee reads the stored stats of the chain's node
+func (chain *BlockChain) EstimateFee(blocks int) (int, error) {
+	return chain.feeStats().EstimateFee(blocks)
+}
+
+func (chain *BlockChain) feeStats() FeeStats {
+	var stats FeeStats
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(feeStatsKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return gob.NewDecoder(bytes.NewReader(val)).Decode(&stats)
+		})
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		Handle(err)
+	}
+	return stats
+}
+
+func (chain *BlockChain) storeFeeStats(stats FeeStats) {
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(stats)
+	Handle(err)
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(feeStatsKey, encoded.Bytes())
+	})
+	Handle(err)
+}
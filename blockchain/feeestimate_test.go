@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeEstimator(t *testing.T) {
+	chain, address := newTestChain(t)
+	estimator := NewFeeEstimator(chain)
+	_, err := chain.EstimateFee(1)
+	assert.Equal(t, ErrNoFeeEstimate, err)
+
+	// The fee of a pool transaction comes from the outputs it spends
+	a := wallet.MakeWallet()
+	funding := createBlock([]*Transaction{CoinbaseTx(string(a.Address()), "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(funding))
+	tx := NewTransaction(a, address, 5, 3, &UTXOSet{Blockchain: chain})
+	estimator.Track(tx)
+	rate := FeeRate(3, tx.Size())
+	assert.Equal(t, trackedTx{feeBucket(rate), 1}, estimator.pending[hex.EncodeToString(tx.ID)])
+
+	// Rates from 64 up confirm in the next block. The cheap ones only confirm
+	// in a block at a height that was already counted.
+	var rich, cheap, cheaper []*Transaction
+	for i := 0; i < 10; i++ {
+		rich = append(rich, &Transaction{ID: []byte(fmt.Sprintf("rich%d", i))})
+		cheap = append(cheap, &Transaction{ID: []byte(fmt.Sprintf("cheap%d", i))})
+		estimator.pending[hex.EncodeToString(rich[i].ID)] = trackedTx{feeBucket(100), 1}
+		estimator.pending[hex.EncodeToString(cheap[i].ID)] = trackedTx{feeBucket(5), 1}
+	}
+	estimator.ProcessBlock(&Block{Height: 2, Transactions: rich})
+	for height := 3; height <= 11; height++ {
+		estimator.ProcessBlock(&Block{Height: height})
+	}
+	estimator.ProcessBlock(&Block{Height: 11, Transactions: cheap})
+	estimator.ProcessBlock(&Block{Height: 12})
+
+	fee, err := chain.EstimateFee(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, fee)
+	fee, err = chain.EstimateFee(MaxFeeTarget)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, fee, "the block at an already counted height was a reorganization")
+
+	for i := 0; i < 10; i++ {
+		cheaper = append(cheaper, &Transaction{ID: []byte(fmt.Sprintf("cheaper%d", i))})
+		estimator.pending[hex.EncodeToString(cheaper[i].ID)] = trackedTx{feeBucket(5), 12}
+	}
+	estimator.ProcessBlock(&Block{Height: 22, Transactions: cheaper})
+	fee, err = chain.EstimateFee(10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, fee)
+	fee, err = chain.EstimateFee(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, fee)
+}
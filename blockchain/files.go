@@ -0,0 +1,50 @@
+package blockchain
+
+import "errors"
+
+// Files returns the file registry: every file transaction on the main chain
+// keyed by file hash. Later uploads of the same content replace earlier ones.
+func (bc *BlockChain) Files() map[string]*FileUploadTransaction {
+	files := make(map[string]*FileUploadTransaction)
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.FileTxs {
+			if _, ok := files[tx.FileHash]; !ok {
+				files[tx.FileHash] = tx
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return files
+}
+
+// FindFile looks up the most recent file transaction for a file hash
+func (bc *BlockChain) FindFile(fileHash string) (*FileUploadTransaction, error) {
+	tx, ok := bc.Files()[fileHash]
+	if !ok {
+		return nil, errors.New("File is not registered on the chain")
+	}
+	return tx, nil
+}
+
+// FindObject resolves a stored object hash to the file transaction that
+// registered it. The index is the shard index, or -1 for the whole file.
+func (bc *BlockChain) FindObject(hash string) (*FileUploadTransaction, int, error) {
+	for _, tx := range bc.Files() {
+		if tx.FileHash == hash {
+			return tx, -1, nil
+		}
+		if tx.Erasure == nil {
+			continue
+		}
+		for _, shard := range tx.Erasure.Shards {
+			if shard.Hash == hash {
+				return tx, shard.Index, nil
+			}
+		}
+	}
+	return nil, 0, errors.New("Object is not registered on the chain")
+}
@@ -0,0 +1,295 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	workPrefix   = []byte("work-")
+	orphanPrefix = []byte("orphan-")
+
+	// connectMu serialises every change of the tip and the UTXO set
+	connectMu sync.Mutex
+)
+
+const (
+	// maxOrphans bounds how many blocks wait for their parent at once
+	maxOrphans = 100
+	// orphanExpiry is how long an orphan waits before it is dropped
+	orphanExpiry = 20 * 60
+)
+
+// HasBlock reports whether the block is stored, on any branch
+func (chain *BlockChain) HasBlock(hash []byte) bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(hash)
+		return err
+	})
+	return err == nil
+}
+
+// ChainWork is the cumulative fork-choice weight from genesis up to and
+// including the block. Blocks stored before work was tracked get it filled in on demand.
+func (chain *BlockChain) ChainWork(hash []byte) (*big.Int, error) {
+	var work []byte
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(workKey(hash))
+		if err != nil {
+			return err
+		}
+		work, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == nil {
+		return new(big.Int).SetBytes(work), nil
+	}
+	if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	total := chain.engine().Weight(&block)
+	if len(block.PrevHash) > 0 {
+		prevWork, err := chain.ChainWork(block.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, prevWork)
+	}
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(workKey(hash), total.Bytes())
+	})
+	return total, err
+}
+
+// AddBlock validates and stores a block received from a peer. Nothing is
+// stored before the hash is shown to be the header's, so a forged block
+// cannot take the place of the real one. Blocks whose parent is not known
+// yet are kept as orphans, a bounded number for a limited time, and
+// validated once it arrives. Once the block is linked into the tree, the
+// branch with the most cumulative work becomes the main chain, reorganising
+// the UTXO set if the tip moves to another branch.
+func (chain *BlockChain) AddBlock(block *Block) error {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	if chain.HasBlock(block.Hash) {
+		return nil
+	}
+	if err := CheckBlock(block); err != nil {
+		return err
+	}
+	if err := chain.engine().CheckHeader(block); err != nil {
+		return err
+	}
+
+	orphan := false
+	if len(block.PrevHash) > 0 {
+		_, err := chain.ChainWork(block.PrevHash)
+		orphan = err != nil
+	}
+	if orphan && chain.pruneOrphans() >= maxOrphans {
+		fmt.Printf("Too many orphans, dropping block %x\n", block.Hash)
+		return nil
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if orphan {
+			err := txn.Set(orphanKey(block.PrevHash, block.Hash), ToHex(time.Now().Unix()))
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Set(block.Hash, block.Serialize())
+	})
+	Handle(err)
+
+	if orphan {
+		fmt.Printf("Block %x is an orphan, waiting for %x\n", block.Hash, block.PrevHash)
+		return nil
+	}
+	return chain.acceptBlock(block)
+}
+
+// acceptBlock validates a block whose parent is known, switches to its
+// branch if that branch now has the most work and then accepts any orphans
+// that were waiting for it. Invalid blocks are removed again.
+func (chain *BlockChain) acceptBlock(block *Block) error {
+	if err := chain.ValidateBlock(block); err != nil {
+		err2 := chain.Database.Update(func(txn *badger.Txn) error {
+			return txn.Delete(block.Hash)
+		})
+		Handle(err2)
+		return err
+	}
+
+	work, err := chain.ChainWork(block.Hash)
+	Handle(err)
+	tipWork, err := chain.ChainWork(chain.LastHash)
+	Handle(err)
+
+	if work.Cmp(tipWork) > 0 {
+		chain.reorganize(block)
+	}
+
+	for _, child := range chain.orphans(block.Hash) {
+		err = chain.Database.Update(func(txn *badger.Txn) error {
+			return txn.Delete(orphanKey(block.Hash, child.Hash))
+		})
+		Handle(err)
+		if err := chain.acceptBlock(child); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+// reorganize makes newTip the tip, disconnecting the blocks of the old
+// branch down to the fork point and connecting the new branch on top
+func (chain *BlockChain) reorganize(newTip *Block) {
+	oldTip, err := chain.GetBlock(chain.LastHash)
+	Handle(err)
+
+	var disconnect, connect []*Block
+	oldBlock, newBlock := &oldTip, newTip
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			disconnect = append(disconnect, oldBlock)
+			oldBlock = chain.parent(oldBlock)
+		} else {
+			connect = append(connect, newBlock)
+			newBlock = chain.parent(newBlock)
+		}
+	}
+
+	if len(disconnect) > 0 {
+		fmt.Printf("Reorganising: disconnecting %d blocks, connecting %d\n", len(disconnect), len(connect))
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	reindex := false
+	for _, block := range disconnect {
+		if err := UTXOSet.Rewind(block); err != nil {
+			fmt.Println(err)
+			reindex = true
+			break
+		}
+	}
+
+	if !reindex {
+		for i := len(connect) - 1; i >= 0; i-- {
+			UTXOSet.Update(connect[i])
+		}
+	}
+
+	chain.setTip(newTip)
+
+	if reindex {
+		UTXOSet.Reindex()
+	}
+
+	if chain.TxIndexEnabled() {
+		for _, block := range disconnect {
+			chain.indexTransactions(block, false)
+		}
+		for i := len(connect) - 1; i >= 0; i-- {
+			chain.indexTransactions(connect[i], true)
+		}
+	}
+	if chain.AddrIndexEnabled() {
+		for _, block := range disconnect {
+			chain.indexAddresses(block, false, nil)
+		}
+		for i := len(connect) - 1; i >= 0; i-- {
+			chain.indexAddresses(connect[i], true, chain.spentOutputs(connect[i]))
+		}
+	}
+}
+
+func (chain *BlockChain) parent(block *Block) *Block {
+	prev, err := chain.GetBlock(block.PrevHash)
+	Handle(err)
+	return &prev
+}
+
+func workKey(hash []byte) []byte {
+	return append(append([]byte{}, workPrefix...), hash...)
+}
+
+func orphanKey(prevHash, hash []byte) []byte {
+	key := append([]byte{}, orphanPrefix...)
+	key = append(key, prevHash...)
+	return append(key, hash...)
+}
+
+// pruneOrphans deletes the orphans that waited longer than orphanExpiry and
+// returns how many are left
+func (chain *BlockChain) pruneOrphans() int {
+	left := 0
+	cutoff := time.Now().Unix() - orphanExpiry
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		var expired [][]byte
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		for it.Seek(orphanPrefix); it.ValidForPrefix(orphanPrefix); it.Next() {
+			received, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			if len(received) == 8 && int64(binary.BigEndian.Uint64(received)) >= cutoff {
+				left++
+				continue
+			}
+			expired = append(expired, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		for _, key := range expired {
+			hash := key[len(key)-32:]
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+			if err := txn.Delete(hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	Handle(err)
+	return left
+}
+
+// orphans returns the stored blocks waiting for prevHash
+func (chain *BlockChain) orphans(prevHash []byte) []*Block {
+	var hashes [][]byte
+	prefix := append(append([]byte{}, orphanPrefix...), prevHash...)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			hashes = append(hashes, bytes.TrimPrefix(it.Item().KeyCopy(nil), prefix))
+		}
+		return nil
+	})
+	Handle(err)
+
+	var blocks []*Block
+	for _, hash := range hashes {
+		block, err := chain.GetBlock(hash)
+		Handle(err)
+		blocks = append(blocks, &block)
+	}
+	return blocks
+}
@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChain(t *testing.T) (*BlockChain, string) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	address := string(wallet.MakeWallet().Address())
+	genesis := Genesis(CoinbaseTx(address, Params.GenesisMessage, 0, 0))
+	err = db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), genesis.Hash)
+	})
+	assert.NoError(t, err)
+
+	chain := &BlockChain{LastHash: genesis.Hash, Database: db}
+	chain.setTip(genesis)
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+	return chain, address
+}
+
+// createBlock is CreateBlock dated height seconds ahead, so blocks created
+// within the same second still come after the median time past of their branch
+func createBlock(txs []*Transaction, records Records, prevHash []byte, height int, bits uint32) *Block {
+	block := &Block{
+		Timestamp:    time.Now().Unix() + int64(height),
+		Transactions: txs,
+		Records:      records,
+		PrevHash:     prevHash,
+		Height:       height,
+		Bits:         bits,
+		Version:      BlockVersion,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	Handle(ProofOfWorkEngine{}.Seal(context.Background(), block))
+	return block
+}
+
+func TestReorganize(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis := Block{Hash: chain.LastHash}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	assert.Equal(t, 1, chain.ReindexTransactions())
+	chain.ReindexAddresses()
+
+	a1 := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, genesis.Hash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(a1))
+	assert.Equal(t, a1.Hash, chain.LastHash)
+	assert.Equal(t, 2, UTXOSet.CountTransactions())
+
+	// A competing branch only wins once it carries more work, and its
+	// blocks may arrive tip first
+	b1 := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, genesis.Hash, 1, LegacyBits)
+	b2 := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 0)}, Records{}, b1.Hash, 2, LegacyBits)
+	assert.NoError(t, chain.AddBlock(b2))
+	assert.Equal(t, a1.Hash, chain.LastHash)
+	assert.NoError(t, chain.AddBlock(b1))
+	assert.Equal(t, b2.Hash, chain.LastHash)
+
+	assert.Equal(t, 3, UTXOSet.CountTransactions())
+
+	block, err := chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, b1.Hash, block.Hash)
+	blocks, err := chain.GetBlocksByHeight(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 3)
+	assert.Equal(t, b2.Hash, blocks[2].Hash)
+
+	_, err = chain.LookupTransaction(a1.Transactions[0].ID)
+	assert.Error(t, err)
+	_, block, confirmations, err := chain.GetTransaction(b1.Transactions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, b1.Hash, block.Hash)
+	assert.Equal(t, 2, confirmations)
+
+	pubKeyHash := a1.Transactions[0].Outputs[0].PubKeyHash
+	history := chain.AddressHistory(pubKeyHash)
+	assert.Len(t, history.Entries, 3)
+	assert.Equal(t, 3*Params.Reward, history.Balance)
+	assert.Equal(t, chain.FindUTXO(), utxoSnapshot(t, chain))
+}
+
+func TestAddressHistoryReadOnly(t *testing.T) {
+	chain, address := newTestChain(t)
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	// Reading never builds the index, opening the chain does
+	assert.Empty(t, chain.AddressHistory(pubKeyHash).Entries)
+	assert.False(t, chain.AddrIndexEnabled())
+}
+
+func TestAddressIndexSpends(t *testing.T) {
+	chain, address := newTestChain(t)
+	chain.ReindexAddresses()
+	UTXOSet := UTXOSet{Blockchain: chain}
+	a, b := wallet.MakeWallet(), wallet.MakeWallet()
+
+	funding := createBlock([]*Transaction{CoinbaseTx(string(a.Address()), "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(funding))
+
+	// Spent amounts come from outputs before the block and from earlier
+	// transactions of the same block
+	parent := NewTransaction(a, string(b.Address()), 5, 1, &UTXOSet)
+	child := &Transaction{
+		Inputs:  []TxInput{{ID: parent.ID, Out: 0, PubKey: b.PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(4, address)},
+	}
+	child.ID = child.Hash()
+	child.Sign(*BytesToPrivateKey(b.PrivateKey), map[string]Transaction{hex.EncodeToString(parent.ID): *parent})
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 2), parent, child}, Records{}, chain.LastHash, 2, LegacyBits)
+	assert.NoError(t, chain.AddBlock(block))
+
+	history := chain.AddressHistory(wallet.PublicKeyHash(a.PublicKey))
+	assert.Equal(t, Params.Reward, history.Sent)
+	assert.Equal(t, Params.Reward-6, history.Balance)
+	history = chain.AddressHistory(wallet.PublicKeyHash(b.PublicKey))
+	assert.Equal(t, 5, history.Sent)
+	assert.Equal(t, 0, history.Balance)
+}
+
+func TestValidateBlock(t *testing.T) {
+	chain, address := newTestChain(t)
+
+	greedy := CoinbaseTx(address, "", 1, 0)
+	greedy.Outputs[0].Value = Params.Reward * 2
+	greedy.ID = greedy.Hash()
+	block := createBlock([]*Transaction{greedy}, Records{}, chain.LastHash, 1, LegacyBits)
+	err := chain.AddBlock(block)
+	assert.Equal(t, RejectCoinbase, err.(*BlockError).Reason)
+	assert.False(t, chain.HasBlock(block.Hash))
+
+	block = createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	block.Nonce++
+	err = chain.AddBlock(block)
+	assert.Equal(t, RejectHash, err.(*BlockError).Reason)
+
+	block = createBlock([]*Transaction{CoinbaseTx(address, "", 5, 0)}, Records{}, chain.LastHash, 5, LegacyBits)
+	err = chain.AddBlock(block)
+	assert.Equal(t, RejectPrevLink, err.(*BlockError).Reason)
+}
+
+func TestSideBranchSpends(t *testing.T) {
+	chain, address := newTestChain(t)
+	UTXOSet := UTXOSet{Blockchain: chain}
+	w := wallet.MakeWallet()
+
+	funding := createBlock([]*Transaction{CoinbaseTx(string(w.Address()), "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(funding))
+	spend := NewTransaction(w, address, 5, 1, &UTXOSet)
+	respend := NewTransaction(w, address, 5, 2, &UTXOSet)
+
+	main := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 1), spend}, Records{}, funding.Hash, 2, LegacyBits)
+	assert.NoError(t, chain.AddBlock(main))
+	main3 := createBlock([]*Transaction{CoinbaseTx(address, "", 3, 0)}, Records{}, main.Hash, 3, LegacyBits)
+	assert.NoError(t, chain.AddBlock(main3))
+
+	// The side branch sees the coin the main chain spent above the fork
+	side := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 2), respend}, Records{}, funding.Hash, 2, LegacyBits)
+	assert.NoError(t, chain.AddBlock(side))
+	assert.Equal(t, main3.Hash, chain.LastHash)
+
+	// but not twice on the same branch
+	again := createBlock([]*Transaction{CoinbaseTx(address, "", 3, 1), spend}, Records{}, side.Hash, 3, LegacyBits)
+	err := chain.AddBlock(again)
+	assert.Equal(t, RejectDoubleSpend, err.(*BlockError).Reason)
+
+	// and a branch below the funding block never had it
+	early := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 1), spend}, Records{}, funding.PrevHash, 1, LegacyBits)
+	err = chain.AddBlock(early)
+	assert.Equal(t, RejectMissingInput, err.(*BlockError).Reason)
+}
+
+func TestOrphans(t *testing.T) {
+	chain, address := newTestChain(t)
+	parent := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	child := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 0)}, Records{}, parent.Hash, 2, LegacyBits)
+
+	// A forged orphan under the child's hash is refused before it is
+	// stored, so the real child is still taken afterwards
+	forged := *child
+	forged.Transactions = []*Transaction{CoinbaseTx(address, "forged", 2, 0)}
+	forged.MerkleRoot = forged.HashTransactions()
+	err := chain.AddBlock(&forged)
+	assert.Equal(t, RejectHash, err.(*BlockError).Reason)
+	assert.False(t, chain.HasBlock(child.Hash))
+
+	assert.NoError(t, chain.AddBlock(child))
+	assert.True(t, chain.HasBlock(child.Hash))
+	assert.NoError(t, chain.AddBlock(parent))
+	assert.Equal(t, child.Hash, chain.LastHash)
+
+	// Expired orphans make room, a full pool drops new ones
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		for i := 0; i < maxOrphans; i++ {
+			hash := make([]byte, 32)
+			hash[0] = byte(i)
+			if err := txn.Set(orphanKey([]byte("missing"), hash), ToHex(time.Now().Unix())); err != nil {
+				return err
+			}
+		}
+		return txn.Set(orphanKey([]byte("missing"), []byte("stale-orphan-hash-of-32-bytes!!!")), ToHex(0))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, maxOrphans, chain.pruneOrphans())
+	late := createBlock([]*Transaction{CoinbaseTx(address, "", 4, 0)}, Records{}, []byte("unknown parent"), 4, LegacyBits)
+	assert.NoError(t, chain.AddBlock(late))
+	assert.False(t, chain.HasBlock(late.Hash))
+}
+
+func TestBlockTimestamps(t *testing.T) {
+	chain, address := newTestChain(t)
+	genesis, err := chain.GetBlock(chain.LastHash)
+	assert.NoError(t, err)
+
+	// A block at the median time past of its parent or too far ahead of
+	// the clock is rejected
+	retime := func(block *Block, timestamp int64) *Block {
+		block.Timestamp = timestamp
+		assert.NoError(t, ProofOfWorkEngine{}.Seal(context.Background(), block))
+		return block
+	}
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, genesis.Hash, 1, LegacyBits)
+	err = chain.AddBlock(retime(block, genesis.Timestamp))
+	assert.Equal(t, RejectTimestamp, err.(*BlockError).Reason)
+	err = chain.AddBlock(retime(block, time.Now().Unix()+Params.MaxFutureDrift+60))
+	assert.Equal(t, RejectTimestamp, err.(*BlockError).Reason)
+	assert.False(t, chain.HasBlock(block.Hash))
+
+	// Blocks from before the rule are only taken below the upgrade height,
+	// and even there cannot follow a block that is held to it
+	block.Version, block.Bits = 0, 0
+	block.MerkleRoot = block.HashTransactions()
+	err = chain.AddBlock(retime(block, genesis.Timestamp))
+	assert.Equal(t, RejectVersion, err.(*BlockError).Reason)
+	assert.Contains(t, err.Error(), "required from height")
+	defer func(height int) { Params.UpgradeHeight = height }(Params.UpgradeHeight)
+	Params.UpgradeHeight = 100
+	err = chain.AddBlock(retime(block, genesis.Timestamp))
+	assert.Equal(t, RejectVersion, err.(*BlockError).Reason)
+	assert.Contains(t, err.Error(), "follows version")
+	Params.UpgradeHeight = 0
+
+	// Mined blocks move past the median time even if the clock lags
+	for i := 0; i < 3; i++ {
+		block := createBlock([]*Transaction{CoinbaseTx(address, "", i+1, 0)}, Records{}, chain.LastHash, i+1, LegacyBits)
+		assert.NoError(t, chain.AddBlock(retime(block, genesis.Timestamp+3600+int64(i))))
+	}
+	tip, err := chain.GetBlock(chain.LastHash)
+	assert.NoError(t, err)
+	assert.Equal(t, genesis.Timestamp+3601, chain.MedianTimePast(&tip))
+	mined, err := chain.MineBlock([]*Transaction{CoinbaseTx(address, "", 4, 0)}, Records{})
+	assert.NoError(t, err)
+	assert.Equal(t, genesis.Timestamp+3602, mined.Timestamp)
+}
+
+func utxoSnapshot(t *testing.T, chain *BlockChain) map[string]TxOutputs {
+	snapshot := make(map[string]TxOutputs)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			snapshot[hex.EncodeToString(it.Item().Key()[prefixLength:])] = DeserializeOutputs(val)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	return snapshot
+}
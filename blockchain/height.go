@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var heightPrefix = []byte("height-")
+
+func heightKey(height int) []byte {
+	key := append([]byte{}, heightPrefix...)
+	return binary.BigEndian.AppendUint64(key, uint64(height))
+}
+
+// indexHeights points the height index at the blocks of the main chain
+// from block down to the first height that already matches
+func (chain *BlockChain) indexHeights(txn *badger.Txn, block *Block) error {
+	for {
+		item, err := txn.Get(heightKey(block.Height))
+		if err == nil {
+			indexed, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(indexed, block.Hash) {
+				return nil
+			}
+		}
+		if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+
+		item, err = txn.Get(block.PrevHash)
+		if err != nil {
+			return err
+		}
+		err = item.Value(func(val []byte) error {
+			block = Deserialize(val)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setTip moves lh to block and updates the height index to match,
+// dropping entries above the new tip after a reorg onto a shorter branch
+func (chain *BlockChain) setTip(block *Block) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for height := block.Height + 1; ; height++ {
+			if _, err := txn.Get(heightKey(height)); err == badger.ErrKeyNotFound {
+				break
+			}
+			if err := txn.Delete(heightKey(height)); err != nil {
+				return err
+			}
+		}
+		if err := chain.indexHeights(txn, block); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	Handle(err)
+	chain.LastHash = block.Hash
+}
+
+// GetBlockByHeight returns the main chain block at height
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	var hash []byte
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightKey(height))
+		if err != nil {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return Block{}, err
+	}
+	return chain.GetBlock(hash)
+}
+
+// GetBlocksByHeight returns the main chain blocks from height from to to,
+// both inclusive, stopping early at the tip
+func (chain *BlockChain) GetBlocksByHeight(from, to int) ([]Block, error) {
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid height range %d-%d", from, to)
+	}
+
+	var blocks []Block
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(heightKey(from)); it.ValidForPrefix(heightPrefix); it.Next() {
+			if bytes.Compare(it.Item().Key(), heightKey(to)) > 0 {
+				break
+			}
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			item, err := txn.Get(hash)
+			if err != nil {
+				return err
+			}
+			err = item.Value(func(val []byte) error {
+				blocks = append(blocks, *Deserialize(val))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return blocks, err
+}
@@ -1,6 +1,7 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"log"
 )
@@ -63,3 +64,60 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 
 	return &tree
 }
+
+// MerkleStep is one sibling on the path from a leaf to the root
+type MerkleStep struct {
+	Hash []byte
+	Left bool // sibling sits to the left of the running hash
+}
+
+// MerkleProof returns the path proving data[index] is part of the tree that
+// NewMerkleTree builds over data
+func MerkleProof(data [][]byte, index int) []MerkleStep {
+	if index < 0 || index >= len(data) {
+		log.Panic("Merkle proof index out of range")
+	}
+
+	var level [][]byte
+	for _, dat := range data {
+		level = append(level, NewMerkleNode(nil, nil, dat).Data)
+	}
+
+	var proof []MerkleStep
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		if index%2 == 0 {
+			proof = append(proof, MerkleStep{level[index+1], false})
+		} else {
+			proof = append(proof, MerkleStep{level[index-1], true})
+		}
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, hashPair(level[i], level[i+1]))
+		}
+		level = next
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that leaf hashes up to root along proof
+func VerifyMerkleProof(root, leaf []byte, proof []MerkleStep) bool {
+	hash := NewMerkleNode(nil, nil, leaf).Data
+	for _, step := range proof {
+		if step.Left {
+			hash = hashPair(step.Hash, hash)
+		} else {
+			hash = hashPair(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+func hashPair(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return hash[:]
+}
@@ -50,3 +50,19 @@ func TestNewMerkleNode(t *testing.T) {
 
 }
 
+func TestMerkleProof(t *testing.T) {
+	data := [][]byte{
+		[]byte("chunk1"),
+		[]byte("chunk2"),
+		[]byte("chunk3"),
+		[]byte("chunk4"),
+		[]byte("chunk5"),
+	}
+	root := NewMerkleTree(data).RootNode.Data
+
+	for i, leaf := range data {
+		proof := MerkleProof(data, i)
+		assert.True(t, VerifyMerkleProof(root, leaf, proof), "proof for leaf %d verifies", i)
+		assert.False(t, VerifyMerkleProof(root, []byte("forged"), proof), "forged leaf %d is rejected", i)
+	}
+}
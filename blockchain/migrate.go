@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var encodingKey = []byte("encoding")
+
+// encodingMigrated reports whether the database already stores blocks and
+// outputs in the canonical encoding
+func (chain *BlockChain) encodingMigrated() bool {
+	migrated := false
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodingKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			migrated = bytes.Equal(val, []byte{encodingVersion})
+			return nil
+		})
+	})
+	Handle(err)
+	return migrated
+}
+
+func (chain *BlockChain) setEncodingMigrated() {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(encodingKey, []byte{encodingVersion})
+	})
+	Handle(err)
+}
+
+// MigrateEncoding rewrites blocks stored with gob in the canonical encoding
+// and returns how many it converted. Blocks keep their version, so their
+// hashes stay valid. The UTXO set is rebuilt in the new encoding and undo
+// data written with gob is dropped; disconnecting those blocks falls back
+// to a full reindex.
+func (chain *BlockChain) MigrateEncoding() int {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	// Blocks are the only values stored under a bare 32 byte hash
+	converted := make(map[string][]byte)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) != 32 {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if _, err := DecodeBlock(value); err == nil {
+				continue
+			}
+			block, err := deserializeLegacy(value)
+			if err != nil || !bytes.Equal(block.Hash, key) {
+				return fmt.Errorf("cannot read stored block %x: %v", key, err)
+			}
+			converted[string(key)] = block.Serialize()
+		}
+		return nil
+	})
+	Handle(err)
+
+	for key, value := range converted {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(key), value)
+		})
+		Handle(err)
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.DeleteByPrefix([]byte("undo-"))
+	UTXOSet.Reindex()
+
+	chain.setEncodingMigrated()
+	return len(converted)
+}
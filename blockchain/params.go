@@ -0,0 +1,271 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+)
+
+var paramsKey = []byte("params")
+
+// Allocation pays Amount to Address in the genesis block
+type Allocation struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// ChainParams are the genesis contents and rules of a network. Nodes that
+// load the same params build the same genesis block.
+type ChainParams struct {
+	ChainID          string          `json:"chain_id"`
+	GenesisTimestamp int64           `json:"genesis_timestamp"` // Unix time of the genesis block
+	GenesisMessage   string          `json:"genesis_message"`
+	Allocations      []Allocation    `json:"allocations,omitempty"`
+	Reward           int             `json:"reward"`            // Coinbase subsidy before the first halving
+	HalvingInterval  int             `json:"halving_interval"`  // Blocks between subsidy halvings, 0 never halves
+	MaxSupply        int             `json:"max_supply"`        // Cap on coins ever issued, genesis included, 0 for none
+	GenesisBits      uint32          `json:"genesis_bits"`      // Compact target of the genesis block
+	BlockInterval    int64           `json:"block_interval"`    // Seconds retargeting aims for between blocks
+	RetargetInterval int             `json:"retarget_interval"` // Blocks between difficulty changes
+	MaxFutureDrift   int64           `json:"max_future_drift"`  // Seconds a block may be ahead of the local clock
+	UpgradeHeight    int             `json:"upgrade_height"`    // First height whose blocks must seal their version and height, required for chains created before it
+	AddressVersion   byte            `json:"address_version"`
+	BlocklistSigners []string        `json:"blocklist_signers,omitempty"` // Addresses allowed to change the blocklist
+	DBPath           string          `json:"db_path"`                     // %s is replaced by the node ID
+	Consensus        ConsensusConfig `json:"consensus"`
+}
+
+// Params are the rules of the chain this process works with. They are set
+// once at startup, by UseParams or LoadStoredParams, and only read after.
+var Params = DefaultParams()
+
+// DefaultParams match the chains created before params were configurable
+func DefaultParams() ChainParams {
+	return ChainParams{
+		ChainID:          "ransumgo",
+		GenesisTimestamp: 1704067200,
+		GenesisMessage:   "First Transaction from Genesis",
+		GenesisBits:      LegacyBits,
+		Reward:           20,
+		HalvingInterval:  210000,
+		MaxSupply:        8400000,
+		BlockInterval:    60,
+		RetargetInterval: 10,
+		MaxFutureDrift:   2 * 60 * 60,
+		AddressVersion:   0x00,
+		DBPath:           "./tmp/blocks_%s",
+	}
+}
+
+// LoadParams reads chain params from a JSON genesis file. Fields the file
+// leaves out keep their default.
+func LoadParams(path string) (ChainParams, error) {
+	params := DefaultParams()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return params, err
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return params, err
+	}
+	return params, params.Validate()
+}
+
+// Validate checks the params describe a chain that can be created
+func (p ChainParams) Validate() error {
+	if p.ChainID == "" {
+		return errors.New("chain_id must be set")
+	}
+	if p.Reward < 0 || p.HalvingInterval < 0 || p.MaxSupply < 0 {
+		return errors.New("reward, halving_interval and max_supply cannot be negative")
+	}
+	if p.BlockInterval <= 0 || p.RetargetInterval <= 0 || p.MaxFutureDrift <= 0 {
+		return errors.New("block_interval, retarget_interval and max_future_drift must be positive")
+	}
+	if p.UpgradeHeight < 0 {
+		return errors.New("upgrade_height cannot be negative")
+	}
+	// Every node must build the same genesis, so nothing in it may depend
+	// on the clock or on fallbacks
+	if p.GenesisTimestamp <= 0 {
+		return errors.New("genesis_timestamp must be set")
+	}
+	if target := CompactToBig(p.GenesisBits); target.Sign() <= 0 || target.Cmp(PowLimit) > 0 {
+		return fmt.Errorf("genesis_bits %08x is not a valid target", p.GenesisBits)
+	}
+	for _, alloc := range p.Allocations {
+		if !wallet.ValidateAddressVersion(alloc.Address, p.AddressVersion) {
+			return fmt.Errorf("allocation address %q is not valid", alloc.Address)
+		}
+		if alloc.Amount <= 0 {
+			return fmt.Errorf("allocation to %s must be positive", alloc.Address)
+		}
+	}
+	for _, signer := range p.BlocklistSigners {
+		if !wallet.ValidateAddressVersion(signer, p.AddressVersion) {
+			return fmt.Errorf("blocklist signer %q is not valid", signer)
+		}
+	}
+	if p.MaxSupply > 0 && p.genesisSupply() > p.MaxSupply {
+		return fmt.Errorf("genesis issues %d coins, more than max_supply %d", p.genesisSupply(), p.MaxSupply)
+	}
+	return p.Consensus.Validate()
+}
+
+// UseParams makes p the params of this process. It must not be called
+// while chains are in use.
+func UseParams(p ChainParams) {
+	Params = p
+	wallet.Version = p.AddressVersion
+}
+
+// GenesisBlock builds the first block of the chain. It pays every
+// allocation, or the reward to address when there are none. The nonce is
+// searched in order from zero, so equal params give equal genesis hashes.
+func (p ChainParams) GenesisBlock(address string) (*Block, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	allocations := p.Allocations
+	if len(allocations) == 0 {
+		if address == "" || !wallet.ValidateAddress(address) {
+			return nil, errors.New("genesis needs an address or allocations")
+		}
+		allocations = []Allocation{{Address: address, Amount: p.Reward}}
+	}
+
+	coinbase := &Transaction{Inputs: []TxInput{{ID: []byte{}, Out: -1, Signature: p.genesisCommitment(), PubKey: []byte(p.GenesisMessage)}}}
+	for _, alloc := range allocations {
+		coinbase.Outputs = append(coinbase.Outputs, *NewTXOutput(alloc.Amount, alloc.Address))
+	}
+	coinbase.ID = coinbase.Hash()
+
+	block := &Block{
+		Version:      BlockVersion,
+		Timestamp:    p.GenesisTimestamp,
+		Transactions: []*Transaction{coinbase},
+		PrevHash:     []byte{},
+		Bits:         p.GenesisBits,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Nonce, block.Hash = NewProof(block).Run()
+	return block, nil
+}
+
+// genesisCommitment binds the genesis to the chain id and the consensus
+// rules, so networks that differ in either never share a genesis hash
+func (p ChainParams) genesisCommitment() []byte {
+	consensus := p.Consensus
+	if consensus.Engine == "" {
+		consensus.Engine = EnginePoW
+	}
+	data, err := json.Marshal(consensus)
+	Handle(err)
+	hash := sha256.Sum256(append([]byte(p.ChainID+"\x00"), data...))
+	return hash[:]
+}
+
+// LoadStoredParams makes the params stored with the node's chain the params
+// of this process. It runs once at startup, before anything reads Params,
+// so opening the chain later never changes them. Nodes without a chain
+// keep the params they were configured with.
+func LoadStoredParams(nodeID string) error {
+	path := fmt.Sprintf(Params.DBPath, nodeID)
+	if !DBexists(path) {
+		return nil
+	}
+	db, err := openDB(path, badger.DefaultOptions(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stored, err := storedParams(db)
+	if err != nil {
+		return err
+	}
+	if stored.ChainID != Params.ChainID {
+		return fmt.Errorf("database belongs to chain %q, not %q", stored.ChainID, Params.ChainID)
+	}
+	// Where the database lives is a setting of this node, not of the chain
+	stored.DBPath = Params.DBPath
+	UseParams(stored)
+	return nil
+}
+
+// storedParams reads the params stored with the chain. Chains created
+// before params were stored run on the defaults with the consensus engine
+// they recorded, and chains created before the header upgrade take the
+// configured upgrade height. Both are stored here once.
+func storedParams(db *badger.DB) (ChainParams, error) {
+	stored := DefaultParams()
+	var fields map[string]json.RawMessage
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(paramsKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if err := json.Unmarshal(val, &stored); err != nil {
+				return err
+			}
+			return json.Unmarshal(val, &fields)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		stored.ChainID = Params.ChainID
+		stored.DBPath = Params.DBPath
+		err = db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(consensusKey)
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				return gob.NewDecoder(bytes.NewReader(val)).Decode(&stored.Consensus)
+			})
+		})
+	}
+	if err != nil {
+		return stored, err
+	}
+	if _, ok := fields["upgrade_height"]; ok {
+		return stored, nil
+	}
+
+	// The height is a consensus rule, so it cannot be derived from the
+	// blocks this node happens to hold. It must come from the params every
+	// node of the network loads.
+	if Params.UpgradeHeight == 0 {
+		return stored, fmt.Errorf("chain %q was created before the header upgrade, set upgrade_height in its params", stored.ChainID)
+	}
+	stored.UpgradeHeight = Params.UpgradeHeight
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return stored, err
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		return txn.Set(paramsKey, data)
+	})
+	return stored, err
+}
+
+// checkParams makes sure the chain was created with the params in use and
+// picks the consensus engine they name
+func (chain *BlockChain) checkParams() {
+	stored, err := storedParams(chain.Database)
+	Handle(err)
+	if !bytes.Equal(stored.genesisCommitment(), Params.genesisCommitment()) {
+		Handle(fmt.Errorf("database belongs to chain %q with other consensus rules, load its params first", stored.ChainID))
+	}
+	chain.Engine = Params.Consensus.engine()
+}
@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenesisFromParams(t *testing.T) {
+	a, b := string(wallet.MakeWallet().Address()), string(wallet.MakeWallet().Address())
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	genesis := fmt.Sprintf(`{
+		"chain_id": "testnet",
+		"genesis_timestamp": 1700000000,
+		"allocations": [{"address": %q, "amount": 500}, {"address": %q, "amount": 250}],
+		"reward": 10
+	}`, a, b)
+	assert.NoError(t, os.WriteFile(path, []byte(genesis), 0644))
+
+	params, err := LoadParams(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, params.Reward)
+	assert.Equal(t, DefaultParams().RetargetInterval, params.RetargetInterval)
+
+	// Every node loading the file gets the same genesis
+	first, err := params.GenesisBlock("")
+	assert.NoError(t, err)
+	second, err := params.GenesisBlock("")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.True(t, NewProof(first).Validate())
+	assert.Len(t, first.Transactions[0].Outputs, 2)
+
+	// Networks differing in their id or consensus rules never share a genesis
+	params.ChainID = "othernet"
+	other, err := params.GenesisBlock("")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Hash, other.Hash)
+	params.ChainID = "testnet"
+	params.Consensus = ConsensusConfig{Engine: EnginePoA, Validators: []string{hex.EncodeToString(wallet.MakeWallet().PublicKey)}}
+	other, err = params.GenesisBlock("")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Hash, other.Hash)
+
+	params.Allocations[0].Amount = 0
+	assert.Error(t, params.Validate())
+	_, err = DefaultParams().GenesisBlock("")
+	assert.Error(t, err)
+}
+
+func TestDefaultGenesis(t *testing.T) {
+	address := string(wallet.MakeWallet().Address())
+
+	// The default genesis depends on nothing but its params, not the clock
+	first, err := DefaultParams().GenesisBlock(address)
+	assert.NoError(t, err)
+	second, err := DefaultParams().GenesisBlock(address)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, DefaultParams().GenesisTimestamp, first.Timestamp)
+	assert.NotZero(t, first.Bits)
+
+	params := DefaultParams()
+	params.GenesisTimestamp = 0
+	assert.Error(t, params.Validate())
+	params = DefaultParams()
+	params.GenesisBits = 0
+	assert.Error(t, params.Validate())
+
+	// Addresses of another network are not valid here
+	params = DefaultParams()
+	params.AddressVersion = 0x6f
+	params.Allocations = []Allocation{{Address: address, Amount: 1}}
+	assert.Error(t, params.Validate())
+	assert.False(t, wallet.ValidateAddressVersion(address, 0x6f))
+	assert.True(t, wallet.ValidateAddress(address))
+}
+
+func TestLoadStoredParams(t *testing.T) {
+	defer UseParams(Params)
+	params := DefaultParams()
+	params.ChainID = "storednet"
+	params.Reward = 7
+	params.DBPath = filepath.Join(t.TempDir(), "blocks_%s")
+	UseParams(params)
+	chain := InitBlockChain(string(wallet.MakeWallet().Address()), "1")
+	chain.Database.Close()
+
+	// Only the chain id has to be configured, the rest comes from the chain
+	configured := DefaultParams()
+	configured.ChainID = params.ChainID
+	configured.DBPath = params.DBPath
+	UseParams(configured)
+	assert.NoError(t, LoadStoredParams("1"))
+	assert.Equal(t, 7, Params.Reward)
+
+	configured.ChainID = "othernet"
+	UseParams(configured)
+	assert.Error(t, LoadStoredParams("1"))
+}
+
+func TestUpgradeHeightMigration(t *testing.T) {
+	chain, address := newTestChain(t)
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(block))
+
+	// Params stored before the upgrade take the configured height and
+	// never one derived from the local tip
+	legacy, err := json.Marshal(map[string]interface{}{"chain_id": Params.ChainID, "reward": 9})
+	assert.NoError(t, err)
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(paramsKey, legacy)
+	})
+	assert.NoError(t, err)
+	_, err = storedParams(chain.Database)
+	assert.Error(t, err)
+
+	defer func(height int) { Params.UpgradeHeight = height }(Params.UpgradeHeight)
+	Params.UpgradeHeight = 5
+	stored, err := storedParams(chain.Database)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, stored.Reward)
+	assert.Equal(t, 5, stored.UpgradeHeight)
+
+	// and keep it once stored
+	Params.UpgradeHeight = 0
+	again, err := storedParams(chain.Database)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, again)
+}
@@ -0,0 +1,458 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/rudrasantadip/ransumgo/wallet"
+)
+
+const (
+	EnginePoW = "pow"
+	EnginePoA = "poa"
+
+	VoteAdd    = "add"
+	VoteRemove = "remove"
+)
+
+var (
+	// consensusKey held the engine of chains created before it was part of
+	// the chain params
+	consensusKey = []byte("consensus")
+
+	ErrNotInTurn = errors.New("not this validator's turn to seal")
+)
+
+// ConsensusConfig selects the engine a chain runs. For proof-of-authority
+// it lists the hex encoded public keys of the validators trusted at genesis.
+// It is part of the chain params and fixed when the chain is created.
+type ConsensusConfig struct {
+	Engine     string   `json:"engine"`
+	Validators []string `json:"validators,omitempty"`
+}
+
+func (c ConsensusConfig) Validate() error {
+	switch c.Engine {
+	case "", EnginePoW:
+		return nil
+	case EnginePoA:
+		if len(c.Validators) == 0 {
+			return errors.New("proof-of-authority needs at least one validator")
+		}
+		for _, key := range c.Validators {
+			pubKey, err := hex.DecodeString(key)
+			if err != nil || len(pubKey) != wallet.PublicKeyLength {
+				return fmt.Errorf("validator %q is not a valid public key", key)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown consensus engine %q", c.Engine)
+}
+
+func (c ConsensusConfig) engine() ConsensusEngine {
+	if c.Engine == EnginePoA {
+		engine := &ProofOfAuthorityEngine{}
+		for _, key := range c.Validators {
+			pubKey, _ := hex.DecodeString(key)
+			engine.Genesis = append(engine.Genesis, keyAddress(pubKey))
+		}
+		return engine
+	}
+	return ProofOfWorkEngine{}
+}
+
+// UseSigner hands a wallet key to the engine for sealing, when the engine
+// seals by signature
+func (chain *BlockChain) UseSigner(w *wallet.Wallet) error {
+	poa, ok := chain.engine().(*ProofOfAuthorityEngine)
+	if !ok {
+		return nil
+	}
+	if err := w.CheckKey(); err != nil {
+		return err
+	}
+	poa.Signer = w
+	return nil
+}
+
+// ValidatorVote proposes adding or removing a validator. It takes effect
+// once more than half of the current validators voted the same way.
+// Epoch counts the validator set changes the vote was cast after, so a
+// vote only counts towards the set it was meant for and cannot be replayed
+// once that set changed.
+type ValidatorVote struct {
+	Action    string
+	Address   string
+	PubKey    []byte
+	Signature []byte
+	Timestamp int64
+	Epoch     int
+}
+
+func NewValidatorVote(w *wallet.Wallet, action, address string, epoch int) (*ValidatorVote, error) {
+	if action != VoteAdd && action != VoteRemove {
+		return nil, fmt.Errorf("unknown vote action %q", action)
+	}
+	if !wallet.ValidateAddress(address) {
+		return nil, errors.New("vote target is not a valid address")
+	}
+	if err := w.CheckKey(); err != nil {
+		return nil, err
+	}
+
+	vote := &ValidatorVote{
+		Action:    action,
+		Address:   address,
+		PubKey:    w.PublicKey,
+		Timestamp: time.Now().Unix(),
+		Epoch:     epoch,
+	}
+	vote.Signature = signHash(w, vote.Hash())
+	return vote, nil
+}
+
+// Hash covers every field except the signature
+func (vote *ValidatorVote) Hash() []byte {
+	return vote.hashAt(BlockVersion)
+}
+
+// hashAt is Hash as committed by blocks of the given version
+func (vote *ValidatorVote) hashAt(version int) []byte {
+	if version == 0 {
+		return vote.legacyHash()
+	}
+	voteCopy := *vote
+	voteCopy.Signature = nil
+
+	e := newEncoder()
+	e.vote(&voteCopy)
+	hash := sha256.Sum256(e.buf)
+	return hash[:]
+}
+
+// legacyHash is Hash over gob, as committed by version 0 blocks
+func (vote *ValidatorVote) legacyHash() []byte {
+	voteCopy := *vote
+	voteCopy.Signature = nil
+
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(voteCopy)
+	Handle(err)
+
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+// Verify checks the vote is signed over its canonical encoding
+func (vote *ValidatorVote) Verify() bool {
+	return vote.verifyAt(BlockVersion)
+}
+
+// verifyAt checks the signature by the rules of the block version the vote
+// is in. Only votes already stored in version 0 blocks are signed over gob.
+func (vote *ValidatorVote) verifyAt(version int) bool {
+	return verifyHash(vote.PubKey, vote.hashAt(version), vote.Signature)
+}
+
+func (vote *ValidatorVote) Serialize() []byte {
+	e := newEncoder()
+	e.vote(vote)
+	return e.buf
+}
+
+// DecodeValidatorVote reads a vote in the canonical encoding
+func DecodeValidatorVote(data []byte) (*ValidatorVote, error) {
+	d := newDecoder(data)
+	vote := d.vote()
+	return vote, d.finish()
+}
+
+// Voter is the address of the validator that cast the vote
+func (vote *ValidatorVote) Voter() string {
+	return keyAddress(vote.PubKey)
+}
+
+func (vote ValidatorVote) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("--- Validator vote %s %s:", vote.Action, vote.Address))
+	lines = append(lines, fmt.Sprintf("     Voter:     %s", vote.Voter()))
+	lines = append(lines, fmt.Sprintf("     Epoch:     %d", vote.Epoch))
+	lines = append(lines, fmt.Sprintf("     Timestamp: %d", vote.Timestamp))
+	return strings.Join(lines, "\n")
+}
+
+// ProofOfAuthorityEngine lets a fixed set of validators take turns sealing
+// blocks by signing them. Validators can be added and removed by majority
+// vote on-chain. Every block counts the same for fork choice.
+type ProofOfAuthorityEngine struct {
+	Genesis []string       // Validator addresses at genesis
+	Signer  *wallet.Wallet // Key of this node when it is a validator
+}
+
+// Validators returns the validator set that seals the block after block,
+// sorted by address
+func (e *ProofOfAuthorityEngine) Validators(chain *BlockChain, block *Block) []string {
+	validators, _ := e.replay(chain, block)
+	return validators
+}
+
+// validatorState is the validator set after a block, with its epoch and
+// the tallies still open. It is stored per block, the way chain work is,
+// so the votes of a branch are replayed only once.
+type validatorState struct {
+	Members map[string]bool
+	Epoch   int
+	Tally   map[string]map[string]bool // Voters by action and address
+}
+
+func validatorsKey(hash []byte) []byte {
+	return append([]byte("validators-"), hash...)
+}
+
+// replay applies the votes on the branch ending at block and returns the
+// resulting validator set with its epoch. Each change of the set starts a
+// new epoch and discards the open tallies, so votes cast for an earlier
+// set never count again. Only blocks back to the nearest one with a
+// stored state are replayed.
+func (e *ProofOfAuthorityEngine) replay(chain *BlockChain, block *Block) ([]string, int) {
+	var pending []*Block
+	state, ok := loadValidatorState(chain, block.Hash)
+	for b := block; !ok; {
+		pending = append(pending, b)
+		if len(b.PrevHash) == 0 {
+			state = validatorState{Members: make(map[string]bool)}
+			for _, address := range e.Genesis {
+				state.Members[address] = true
+			}
+			break
+		}
+		b = chain.parent(b)
+		state, ok = loadValidatorState(chain, b.Hash)
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		state.apply(pending[i])
+		storeValidatorState(chain, pending[i].Hash, state)
+	}
+
+	var validators []string
+	for address := range state.Members {
+		validators = append(validators, address)
+	}
+	sort.Strings(validators)
+	return validators, state.Epoch
+}
+
+// apply counts the votes of the block
+func (state *validatorState) apply(block *Block) {
+	if state.Tally == nil {
+		state.Tally = make(map[string]map[string]bool)
+	}
+	members := state.Members
+	for _, vote := range block.VoteTxs {
+		voter := vote.Voter()
+		if !members[voter] || !vote.verifyAt(block.Version) || members[vote.Address] == (vote.Action == VoteAdd) {
+			continue
+		}
+		if block.Version > 0 && vote.Epoch != state.Epoch {
+			continue
+		}
+		if vote.Action == VoteRemove && len(members) == 1 {
+			continue
+		}
+		key := vote.Action + ":" + vote.Address
+		if state.Tally[key] == nil {
+			state.Tally[key] = make(map[string]bool)
+		}
+		state.Tally[key][voter] = true
+		if len(state.Tally[key]) > len(members)/2 {
+			members[vote.Address] = vote.Action == VoteAdd
+			if !members[vote.Address] {
+				delete(members, vote.Address)
+			}
+			state.Epoch++
+			state.Tally = make(map[string]map[string]bool)
+		}
+	}
+}
+
+func loadValidatorState(chain *BlockChain, hash []byte) (validatorState, bool) {
+	var state validatorState
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(validatorsKey(hash))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return gob.NewDecoder(bytes.NewReader(val)).Decode(&state)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return state, false
+	}
+	Handle(err)
+	if state.Members == nil {
+		state.Members = make(map[string]bool)
+	}
+	return state, true
+}
+
+func storeValidatorState(chain *BlockChain, hash []byte, state validatorState) {
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(state)
+	Handle(err)
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(validatorsKey(hash), encoded.Bytes())
+	})
+	Handle(err)
+}
+
+// InTurn is the validator expected to seal the block at height
+func InTurn(validators []string, height int) string {
+	if len(validators) == 0 {
+		return ""
+	}
+	return validators[height%len(validators)]
+}
+
+// Validators returns the validator set that seals the next block on the tip
+func (chain *BlockChain) Validators() ([]string, error) {
+	poa, ok := chain.engine().(*ProofOfAuthorityEngine)
+	if !ok {
+		return nil, errors.New("chain does not use proof-of-authority")
+	}
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	return poa.Validators(chain, &tip), nil
+}
+
+// VoteEpoch is the epoch new validator votes on the tip are cast for
+func (chain *BlockChain) VoteEpoch() (int, error) {
+	poa, ok := chain.engine().(*ProofOfAuthorityEngine)
+	if !ok {
+		return 0, errors.New("chain does not use proof-of-authority")
+	}
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return 0, err
+	}
+	_, epoch := poa.replay(chain, &tip)
+	return epoch, nil
+}
+
+// AddVoteBlock seals the validator votes into a new block on the tip
+func (chain *BlockChain) AddVoteBlock(votes ...*ValidatorVote) error {
+	return chain.addRecordsBlock(Records{VoteTxs: votes})
+}
+
+func (e *ProofOfAuthorityEngine) Prepare(chain *BlockChain, parent, block *Block) error {
+	if e.Signer == nil {
+		return errors.New("no validator key to seal with")
+	}
+	signer := string(e.Signer.Address())
+	if InTurn(e.Validators(chain, parent), block.Height) != signer {
+		return ErrNotInTurn
+	}
+	block.Signer = e.Signer.PublicKey
+	return nil
+}
+
+// sealHash covers the header fields the validator signs
+func sealHash(block *Block) []byte {
+	data := bytes.Join([][]byte{
+		ToHex(int64(block.Version)),
+		block.PrevHash,
+		block.MerkleRoot,
+		ToHex(block.Timestamp),
+		ToHex(int64(block.Height)),
+		block.Signer,
+	}, []byte{})
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+func (e *ProofOfAuthorityEngine) Seal(ctx context.Context, block *Block) error {
+	if e.Signer == nil || !bytes.Equal(block.Signer, e.Signer.PublicKey) {
+		return errors.New("block was not prepared for this validator")
+	}
+	block.Hash = sealHash(block)
+	block.Signature = signHash(e.Signer, block.Hash)
+	return nil
+}
+
+func (e *ProofOfAuthorityEngine) CheckHeader(block *Block) error {
+	if !bytes.Equal(block.Hash, sealHash(block)) {
+		return reject(block, RejectHash, "hash does not match the header")
+	}
+	if !verifyHash(block.Signer, block.Hash, block.Signature) {
+		return reject(block, RejectSigner, "invalid validator signature")
+	}
+	return nil
+}
+
+func (e *ProofOfAuthorityEngine) VerifySeal(chain *BlockChain, parent, block *Block) error {
+	if err := e.CheckHeader(block); err != nil {
+		return err
+	}
+
+	signer := keyAddress(block.Signer)
+	validators := e.Validators(chain, parent)
+	if inTurn := InTurn(validators, block.Height); signer != inTurn {
+		for _, address := range validators {
+			if address == signer {
+				return reject(block, RejectSigner, "%s sealed out of turn, expected %s", signer, inTurn)
+			}
+		}
+		return reject(block, RejectSigner, "%s is not a validator", signer)
+	}
+	return nil
+}
+
+func (e *ProofOfAuthorityEngine) Weight(block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+func keyAddress(pubKey []byte) string {
+	w := wallet.Wallet{PublicKey: pubKey}
+	return string(w.Address())
+}
+
+func signHash(w *wallet.Wallet, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, BytesToPrivateKey(w.PrivateKey), hash)
+	if err != nil {
+		log.Panic(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature
+}
+
+func verifyHash(pubKey, hash, signature []byte) bool {
+	if len(signature) != 64 || len(pubKey) != wallet.PublicKeyLength {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:len(signature)/2])
+	s := new(big.Int).SetBytes(signature[len(signature)/2:])
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	return ecdsa.Verify(&rawPubKey, hash, r, s)
+}
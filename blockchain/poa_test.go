@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+// usePoA switches a test chain to the engine config names, as if its params
+// had named it at genesis
+func usePoA(chain *BlockChain, config ConsensusConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	chain.Engine = config.engine()
+	return nil
+}
+
+func TestProofOfAuthority(t *testing.T) {
+	chain, address := newTestChain(t)
+	a, b, c := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	err := usePoA(chain, ConsensusConfig{
+		Engine:     EnginePoA,
+		Validators: []string{hex.EncodeToString(a.PublicKey), hex.EncodeToString(b.PublicKey)},
+	})
+	assert.NoError(t, err)
+
+	validators, err := chain.Validators()
+	assert.NoError(t, err)
+	assert.Len(t, validators, 2)
+
+	// Whoever is out of turn cannot seal, the other validator can
+	inTurn, outOfTurn := a, b
+	if InTurn(validators, 1) != string(a.Address()) {
+		inTurn, outOfTurn = b, a
+	}
+	chain.UseSigner(outOfTurn)
+	_, err = chain.MineBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{})
+	assert.Equal(t, ErrNotInTurn, err)
+
+	chain.UseSigner(inTurn)
+	block, err := chain.MineBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{})
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, chain.LastHash)
+
+	// A block signed by a stranger is rejected
+	parent := *block
+	forged := createBlock([]*Transaction{CoinbaseTx(address, "", 2, 0)}, Records{}, parent.Hash, 2, 0)
+	forged.Signer = c.PublicKey
+	forged.MerkleRoot = forged.HashTransactions()
+	forged.Hash = sealHash(forged)
+	forged.Signature = signHash(c, forged.Hash)
+	assert.Error(t, chain.AddBlock(forged))
+
+	// One vote is not a majority of two, the second adds c
+	voteA, err := NewValidatorVote(a, VoteAdd, string(c.Address()), 0)
+	assert.NoError(t, err)
+	chain.UseSigner(outOfTurn)
+	assert.NoError(t, chain.AddVoteBlock(voteA))
+	validators, _ = chain.Validators()
+	assert.Len(t, validators, 2)
+
+	voteB, err := NewValidatorVote(b, VoteAdd, string(c.Address()), 0)
+	assert.NoError(t, err)
+	chain.UseSigner(inTurn)
+	assert.NoError(t, chain.AddVoteBlock(voteB))
+	validators, _ = chain.Validators()
+	assert.Contains(t, validators, string(c.Address()))
+}
+
+func TestValidatorVoteEpochs(t *testing.T) {
+	chain, _ := newTestChain(t)
+	a, b, c := wallet.MakeWallet(), wallet.MakeWallet(), wallet.MakeWallet()
+	keys := map[string]*wallet.Wallet{string(a.Address()): a, string(b.Address()): b, string(c.Address()): c}
+	err := usePoA(chain, ConsensusConfig{
+		Engine:     EnginePoA,
+		Validators: []string{hex.EncodeToString(a.PublicKey), hex.EncodeToString(b.PublicKey), hex.EncodeToString(c.PublicKey)},
+	})
+	assert.NoError(t, err)
+
+	seal := func(votes ...*ValidatorVote) {
+		validators, err := chain.Validators()
+		assert.NoError(t, err)
+		assert.NoError(t, chain.UseSigner(keys[InTurn(validators, chain.GetBestHeight()+1)]))
+		assert.NoError(t, chain.AddVoteBlock(votes...))
+	}
+	vote := func(w *wallet.Wallet, action string, target *wallet.Wallet, epoch int) *ValidatorVote {
+		vote, err := NewValidatorVote(w, action, string(target.Address()), epoch)
+		assert.NoError(t, err)
+		return vote
+	}
+
+	removeA := []*ValidatorVote{vote(b, VoteRemove, a, 0), vote(c, VoteRemove, a, 0)}
+	seal(removeA...)
+	validators, _ := chain.Validators()
+	assert.NotContains(t, validators, string(a.Address()))
+	epoch, err := chain.VoteEpoch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, epoch)
+
+	// Votes cast for the old set no longer count, whether new or replayed
+	seal(vote(b, VoteAdd, a, 0), vote(c, VoteAdd, a, 0))
+	validators, _ = chain.Validators()
+	assert.Len(t, validators, 2)
+
+	seal(vote(b, VoteAdd, a, 1), vote(c, VoteAdd, a, 1))
+	validators, _ = chain.Validators()
+	assert.Len(t, validators, 3)
+	seal(removeA...)
+	validators, _ = chain.Validators()
+	assert.Contains(t, validators, string(a.Address()))
+
+	// Every block keeps the set it leaves behind, so the next one only
+	// applies its own votes
+	tip, err := chain.Tip()
+	assert.NoError(t, err)
+	state, ok := loadValidatorState(chain, tip.Hash)
+	assert.True(t, ok)
+	assert.Len(t, state.Members, 3)
+	assert.Equal(t, 2, state.Epoch)
+	_, ok = loadValidatorState(chain, tip.PrevHash)
+	assert.True(t, ok)
+}
+
+func TestValidatorVoteSignatures(t *testing.T) {
+	chain, _ := newTestChain(t)
+	w := wallet.MakeWallet()
+
+	// Votes signed over gob are only honoured inside version 0 blocks
+	vote := &ValidatorVote{Action: VoteAdd, Address: string(w.Address()), PubKey: w.PublicKey}
+	vote.Signature = signHash(w, vote.legacyHash())
+	assert.False(t, vote.Verify())
+	assert.True(t, vote.verifyAt(0))
+
+	// Keys created before padding cannot vote or seal
+	short := &wallet.Wallet{PrivateKey: w.PrivateKey, PublicKey: w.PublicKey[1:]}
+	_, err := NewValidatorVote(short, VoteAdd, string(w.Address()), 0)
+	assert.ErrorIs(t, err, wallet.ErrShortKey)
+	assert.NoError(t, usePoA(chain, ConsensusConfig{Engine: EnginePoA, Validators: []string{hex.EncodeToString(w.PublicKey)}}))
+	assert.ErrorIs(t, chain.UseSigner(short), wallet.ErrShortKey)
+	assert.Error(t, ConsensusConfig{Engine: EnginePoA, Validators: []string{hex.EncodeToString(short.PublicKey)}}.Validate())
+}
@@ -2,12 +2,16 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Take the data from the block
@@ -21,6 +25,8 @@ import (
 // Requirements:
 // The First few bytes must contain 0s
 
+// Difficulty is the fixed number of leading zero bits that blocks without
+// difficulty bits were mined at
 const Difficulty = 12
 
 type ProofOfWork struct {
@@ -29,56 +35,151 @@ type ProofOfWork struct {
 }
 
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target := CompactToBig(EffectiveBits(b))
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
+// InitData is the header the nonce is searched over. Current blocks commit
+// to their version, timestamp, height and bits; version 0 blocks only to
+// their parent and contents, at the fixed Difficulty.
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-
-	return data
+	prefix, suffix := pow.header()
+	return bytes.Join([][]byte{prefix, ToHex(int64(nonce)), suffix}, []byte{})
 }
 
+// header splits InitData around the nonce, so miners hash the
+// transactions once instead of once per attempt
+func (pow *ProofOfWork) header() ([]byte, []byte) {
+	if pow.Block.Version > 0 {
+		prefix := bytes.Join(
+			[][]byte{
+				ToHex(int64(pow.Block.Version)),
+				pow.Block.PrevHash,
+				pow.Block.HashTransactions(),
+				ToHex(pow.Block.Timestamp),
+				ToHex(int64(pow.Block.Height)),
+			},
+			[]byte{},
+		)
+		return prefix, ToHex(int64(pow.Block.Bits))
+	}
+	prefix := bytes.Join([][]byte{pow.Block.PrevHash, pow.Block.HashTransactions()}, []byte{})
+	return prefix, ToHex(int64(Difficulty))
+}
+
+// MiningStats describes a finished or cancelled search
+type MiningStats struct {
+	Hashes  uint64
+	Elapsed time.Duration
+}
+
+// Hashrate is the number of hashes tried per second
+func (s MiningStats) Hashrate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Elapsed.Seconds()
+}
+
+// Run tries nonces in order from zero on one goroutine, so the same block
+// always gets the same nonce. Mine is the fast search for new blocks.
 func (pow *ProofOfWork) Run() (int, []byte) {
+	prefix, suffix := pow.header()
 	var intHash big.Int
-	var hash [32]byte
+	data := make([]byte, 0, len(prefix)+8+len(suffix))
 
-	nonce := 0
+	for nonce := 0; nonce < math.MaxInt64; nonce++ {
+		data = append(data[:0], prefix...)
+		data = binary.BigEndian.AppendUint64(data, uint64(nonce))
+		data = append(data, suffix...)
+		hash := sha256.Sum256(data)
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-
-		fmt.Printf("\r%x", hash)
 		intHash.SetBytes(hash[:])
-
 		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+			return nonce, hash[:]
 		}
+	}
+	log.Panic("nonce space exhausted")
+	return 0, nil
+}
+
+// Mine splits the nonce space across GOMAXPROCS workers, each trying every
+// n-th nonce, and returns the first nonce found. It stops early with the
+// context's error when ctx is cancelled.
+func (pow *ProofOfWork) Mine(ctx context.Context) (int, []byte, MiningStats, error) {
+	prefix, suffix := pow.header()
+	workers := runtime.GOMAXPROCS(0)
 
+	type result struct {
+		nonce int
+		hash  [32]byte
+	}
+	found := make(chan result, workers)
+	done := make(chan struct{})
+	var hashes atomic.Uint64
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+			var intHash big.Int
+			data := make([]byte, 0, len(prefix)+8+len(suffix))
+			tried := uint64(0)
+			defer func() { hashes.Add(tried) }()
+
+			for ; nonce >= 0 && nonce < math.MaxInt64; nonce += workers {
+				// Check for cancellation every few thousand hashes
+				if tried%4096 == 0 {
+					select {
+					case <-done:
+						return
+					case <-ctx.Done():
+						return
+					default:
+					}
+				}
+				data = append(data[:0], prefix...)
+				data = binary.BigEndian.AppendUint64(data, uint64(nonce))
+				data = append(data, suffix...)
+				hash := sha256.Sum256(data)
+				tried++
+
+				intHash.SetBytes(hash[:])
+				if intHash.Cmp(pow.Target) == -1 {
+					found <- result{nonce, hash}
+					return
+				}
+			}
+		}(w)
 	}
-	fmt.Println()
 
-	return nonce, hash[:]
+	stop := func() MiningStats {
+		close(done)
+		wg.Wait()
+		return MiningStats{Hashes: hashes.Load(), Elapsed: time.Since(start)}
+	}
+
+	select {
+	case r := <-found:
+		return r.nonce, r.hash[:], stop(), nil
+	case <-ctx.Done():
+		return 0, nil, stop(), ctx.Err()
+	}
 }
 
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
+	// Blocks mined before the root was stored in the header carry none
+	if len(pow.Block.MerkleRoot) > 0 && !bytes.Equal(pow.Block.MerkleRoot, pow.Block.HashTransactions()) {
+		return false
+	}
+
 	data := pow.InitData(pow.Block.Nonce)
 
 	hash := sha256.Sum256(data)
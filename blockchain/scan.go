@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	VerdictClean     = "clean"
+	VerdictMalicious = "malicious"
+)
+
+// DetectorResult is what a single detector reported about an upload
+type DetectorResult struct {
+	Detector     string
+	Version      string
+	Score        float64
+	Threshold    float64
+	MatchedRules []string
+	Flagged      bool
+}
+
+// ScanResult is the full detection outcome stored with a file transaction
+type ScanResult struct {
+	Verdict   string
+	Detectors []DetectorResult
+	ScannedAt int64
+}
+
+// Flagged returns the detectors that voted against the file
+func (s ScanResult) Flagged() []DetectorResult {
+	var flagged []DetectorResult
+	for _, d := range s.Detectors {
+		if d.Flagged {
+			flagged = append(flagged, d)
+		}
+	}
+	return flagged
+}
+
+func (s ScanResult) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("     Verdict: %s", s.Verdict))
+	for _, d := range s.Detectors {
+		line := fmt.Sprintf("       %s %s: score %.4f (threshold %.4f)", d.Detector, d.Version, d.Score, d.Threshold)
+		if len(d.MatchedRules) > 0 {
+			line += fmt.Sprintf(" rules %s", strings.Join(d.MatchedRules, ", "))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotEntry is one file of a directory snapshot
+type SnapshotEntry struct {
+	Path     string // slash separated, relative to the snapshot root
+	FileHash string
+	Size     int64
+	Mode     uint32
+}
+
+// SnapshotTransaction anchors a whole directory tree on-chain through the
+// Merkle root of its (path, hash) pairs
+type SnapshotTransaction struct {
+	FromAddress string
+	Dir         string
+	Root        []byte
+	Manifest    []SnapshotEntry
+	Timestamp   int64
+}
+
+func NewSnapshotTransaction(fromAddress, dir string, entries []SnapshotEntry) (*SnapshotTransaction, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("cannot snapshot an empty directory")
+	}
+
+	manifest := append([]SnapshotEntry{}, entries...)
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].Path < manifest[j].Path })
+
+	return &SnapshotTransaction{
+		FromAddress: fromAddress,
+		Dir:         dir,
+		Root:        SnapshotRoot(manifest),
+		Manifest:    manifest,
+		Timestamp:   time.Now().Unix(),
+	}, nil
+}
+
+// SnapshotRoot is the Merkle root over the manifest's (path, hash) pairs.
+// The manifest must be sorted by path.
+func SnapshotRoot(manifest []SnapshotEntry) []byte {
+	var leaves [][]byte
+	for _, entry := range manifest {
+		leaves = append(leaves, []byte(entry.Path+"\x00"+entry.FileHash))
+	}
+	return NewMerkleTree(leaves).RootNode.Data
+}
+
+// Validate checks that the manifest really produces the anchored root and
+// that every path stays inside the snapshot root
+func (tx *SnapshotTransaction) Validate() bool {
+	if len(tx.Manifest) == 0 {
+		return false
+	}
+	for _, entry := range tx.Manifest {
+		if !IsSnapshotPath(entry.Path) {
+			return false
+		}
+	}
+	return bytes.Equal(SnapshotRoot(tx.Manifest), tx.Root)
+}
+
+// IsSnapshotPath reports whether p is a clean, relative, slash separated
+// path that cannot leave the directory it is joined to
+func IsSnapshotPath(p string) bool {
+	return p != "" && path.Clean(p) == p && filepath.IsLocal(filepath.FromSlash(p))
+}
+
+func (tx *SnapshotTransaction) Hash() []byte {
+	e := newEncoder()
+	e.snapshotTx(tx)
+	hash := sha256.Sum256(e.buf)
+	return hash[:]
+}
+
+// legacyHash is Hash over gob, as committed by version 0 blocks
+func (tx *SnapshotTransaction) legacyHash() []byte {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(tx)
+	Handle(err)
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+func (tx SnapshotTransaction) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("--- Snapshot %x:", tx.Root))
+	lines = append(lines, fmt.Sprintf("     Dir:       %s", tx.Dir))
+	lines = append(lines, fmt.Sprintf("     From:      %s", tx.FromAddress))
+	lines = append(lines, fmt.Sprintf("     Files:     %d", len(tx.Manifest)))
+	lines = append(lines, fmt.Sprintf("     Timestamp: %d", tx.Timestamp))
+	return strings.Join(lines, "\n")
+}
+
+func (bc *BlockChain) AddSnapshotBlock(txs ...*SnapshotTransaction) error {
+	return bc.addRecordsBlock(Records{SnapshotTxs: txs})
+}
+
+// FindSnapshot returns the snapshot anchored with the given root. An empty
+// root selects the most recent snapshot taken of dir.
+func (bc *BlockChain) FindSnapshot(root []byte, dir string) (*SnapshotTransaction, *Block, error) {
+	iter := bc.Iterator()
+	for {
+		block := iter.Next()
+		for i := len(block.SnapshotTxs) - 1; i >= 0; i-- {
+			tx := block.SnapshotTxs[i]
+			if len(root) > 0 && bytes.Equal(tx.Root, root) {
+				return tx, block, nil
+			}
+			if len(root) == 0 && tx.Dir == dir {
+				return tx, block, nil
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return nil, nil, errors.New("Snapshot does not exist")
+}
@@ -0,0 +1,105 @@
+package blockchain
+
+// Supply is how many coins the chain has issued up to its tip
+type Supply struct {
+	Height      int
+	Issued      int // Paid out by the genesis and every coinbase
+	Remaining   int // Left to issue under the cap, -1 when there is none
+	NextSubsidy int
+}
+
+// genesisSupply is what the genesis block pays out
+func (p ChainParams) genesisSupply() int {
+	if len(p.Allocations) == 0 {
+		return p.Reward
+	}
+	total := 0
+	for _, alloc := range p.Allocations {
+		total += alloc.Amount
+	}
+	return total
+}
+
+// scheduled is the subsidy at height before the cap is applied
+func (p ChainParams) scheduled(height int) int {
+	if p.HalvingInterval == 0 {
+		return p.Reward
+	}
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.Reward >> uint(halvings)
+}
+
+// scheduledBefore is how much the genesis and the blocks below height may
+// issue, summed one halving era at a time
+func (p ChainParams) scheduledBefore(height int) int {
+	total := p.genesisSupply()
+	for start := 1; start < height; {
+		end := height
+		if p.HalvingInterval > 0 {
+			if next := (start/p.HalvingInterval + 1) * p.HalvingInterval; next < end {
+				end = next
+			}
+		}
+		subsidy := p.scheduled(start)
+		if subsidy == 0 {
+			break
+		}
+		total += subsidy * (end - start)
+		if p.MaxSupply > 0 && total >= p.MaxSupply {
+			return p.MaxSupply
+		}
+		start = end
+	}
+	return total
+}
+
+// Subsidy is the most the coinbase of the block at height may pay. The
+// reward halves every HalvingInterval blocks and stops at MaxSupply.
+func (p ChainParams) Subsidy(height int) int {
+	subsidy := p.scheduled(height)
+	if p.MaxSupply > 0 {
+		if left := p.MaxSupply - p.scheduledBefore(height); left < subsidy {
+			subsidy = left
+		}
+	}
+	if subsidy < 0 {
+		return 0
+	}
+	return subsidy
+}
+
+// Supply adds up the coins created by the main chain. Fees a coinbase
+// collects were issued before, so only its subsidy counts.
+func (chain *BlockChain) Supply() Supply {
+	supply := Supply{Height: chain.GetBestHeight()}
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				continue
+			}
+			value := 0
+			for _, out := range tx.Outputs {
+				value += out.Value
+			}
+			if subsidy := Params.Subsidy(block.Height); len(block.PrevHash) > 0 && value > subsidy {
+				value = subsidy
+			}
+			supply.Issued += value
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	supply.NextSubsidy = Params.Subsidy(supply.Height + 1)
+	supply.Remaining = -1
+	if Params.MaxSupply > 0 {
+		supply.Remaining = Params.MaxSupply - supply.Issued
+	}
+	return supply
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubsidySchedule(t *testing.T) {
+	params := ChainParams{Reward: 20, HalvingInterval: 10, MaxSupply: 500}
+	assert.Equal(t, 20, params.Subsidy(1))
+	assert.Equal(t, 20, params.Subsidy(9))
+	assert.Equal(t, 10, params.Subsidy(10))
+	assert.Equal(t, 5, params.Subsidy(25))
+
+	// Genesis 20 plus 9*20 + 10*10 + 10*5 + 10*2 + 10*1 for heights 1 to 49
+	assert.Equal(t, 380, params.scheduledBefore(51))
+	assert.Equal(t, 0, params.Subsidy(60))
+
+	params.MaxSupply = 250
+	assert.Equal(t, 20, params.Subsidy(9))
+	assert.Equal(t, 10, params.Subsidy(10), "genesis and nine blocks issue 200")
+	assert.Equal(t, 0, params.Subsidy(16))
+	assert.Equal(t, 250, params.scheduledBefore(100))
+
+	params.HalvingInterval = 0
+	params.MaxSupply = 0
+	assert.Equal(t, 20, params.Subsidy(1<<40))
+}
+
+func TestOverpayingCoinbase(t *testing.T) {
+	defer UseParams(Params)
+	params := Params
+	params.HalvingInterval = 1
+	UseParams(params)
+
+	chain, address := newTestChain(t)
+	greedy := createBlock([]*Transaction{CoinbaseTx(address, "", 0, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	err := chain.AddBlock(greedy)
+	assert.Equal(t, RejectCoinbase, err.(*BlockError).Reason)
+
+	block := createBlock([]*Transaction{CoinbaseTx(address, "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(block))
+
+	supply := chain.Supply()
+	assert.Equal(t, 1, supply.Height)
+	assert.Equal(t, params.Reward+params.Reward/2, supply.Issued)
+	assert.Equal(t, params.Reward/4, supply.NextSubsidy)
+	assert.Equal(t, params.MaxSupply-supply.Issued, supply.Remaining)
+}
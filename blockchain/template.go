@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// maxTemplateSize bounds the serialized transactions a mined block carries
+const maxTemplateSize = 1 << 20
+
+// Size is the length of the transaction in the canonical encoding
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+// FeeRate is a fee per 1000 bytes of transaction
+func FeeRate(fee, size int) int {
+	if size <= 0 {
+		return 0
+	}
+	return fee * 1000 / size
+}
+
+// templateEntry is a pool transaction whose inputs are all available
+type templateEntry struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+// higherRate compares fee rates without rounding them
+func (e templateEntry) higherRate(other templateEntry) bool {
+	return e.fee*other.size > other.fee*e.size
+}
+
+// BlockTemplate picks the transactions for the next block on the tip,
+// highest fee rate first, until the template is full. A transaction that
+// spends another pool transaction can only follow it. It returns what was
+// picked with the fees it pays and what can never go in on this tip.
+// Transactions that did not fit are in neither list.
+func (chain *BlockChain) BlockTemplate(pool []*Transaction) (txs []*Transaction, fees int, invalid []*Transaction) {
+	return chain.BlockTemplateOn(chain.LastHash, pool)
+}
+
+// BlockTemplateOn is BlockTemplate for the next block on the given tip
+func (chain *BlockChain) BlockTemplateOn(tip []byte, pool []*Transaction) (txs []*Transaction, fees int, invalid []*Transaction) {
+	view := chain.branchView(tip)
+	defer view.discard()
+	pending := make([]*Transaction, 0, len(pool))
+	for _, tx := range pool {
+		if tx.IsCoinbase() || !bytes.Equal(tx.ID, txID(tx, BlockVersion)) {
+			invalid = append(invalid, tx)
+			continue
+		}
+		pending = append(pending, tx)
+	}
+
+	size := 0
+	for len(pending) > 0 {
+		var best *templateEntry
+		bestIndex := -1
+		for i, tx := range pending {
+			fee, err := view.spend(tx)
+			if err != nil {
+				continue
+			}
+			entry := templateEntry{tx, fee, tx.Size()}
+			if size+entry.size > maxTemplateSize {
+				continue
+			}
+			if best == nil || entry.higherRate(*best) {
+				best, bestIndex = &entry, i
+			}
+		}
+		if best == nil {
+			break
+		}
+		view.add(best.tx)
+		txs = append(txs, best.tx)
+		fees += best.fee
+		size += best.size
+		pending = append(pending[:bestIndex], pending[bestIndex+1:]...)
+	}
+
+	// What is left did not fit, waits on a parent that did not fit, or
+	// spends something missing or already spent
+	waiting := make(map[string]bool)
+	for _, tx := range pending {
+		waiting[hex.EncodeToString(tx.ID)] = true
+	}
+	for _, tx := range pending {
+		_, err := view.spend(tx)
+		if err == nil || (err.Reason == RejectMissingInput && spendsAny(tx, waiting)) {
+			continue
+		}
+		invalid = append(invalid, tx)
+	}
+	return txs, fees, invalid
+}
+
+func spendsAny(tx *Transaction, ids map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if ids[hex.EncodeToString(in.ID)] {
+			return true
+		}
+	}
+	return false
+}
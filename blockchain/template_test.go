@@ -0,0 +1,47 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/rudrasantadip/ransumgo/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeesAndTemplate(t *testing.T) {
+	chain, address := newTestChain(t)
+	UTXOSet := UTXOSet{Blockchain: chain}
+	a, b := wallet.MakeWallet(), wallet.MakeWallet()
+
+	// Give both wallets a coin to spend
+	funding := createBlock([]*Transaction{CoinbaseTx(string(a.Address()), "", 1, 0)}, Records{}, chain.LastHash, 1, LegacyBits)
+	assert.NoError(t, chain.AddBlock(funding))
+	funding = createBlock([]*Transaction{CoinbaseTx(string(b.Address()), "", 2, 0)}, Records{}, chain.LastHash, 2, LegacyBits)
+	assert.NoError(t, chain.AddBlock(funding))
+
+	cheap := NewTransaction(a, address, 5, 1, &UTXOSet)
+	rich := NewTransaction(b, address, 5, 4, &UTXOSet)
+	txs, fees, invalid := chain.BlockTemplate([]*Transaction{cheap, rich})
+	assert.Equal(t, []*Transaction{rich, cheap}, txs)
+	assert.Equal(t, 5, fees)
+	assert.Empty(t, invalid)
+
+	// The coinbase may claim the fees but not a coin more
+	greedy := CoinbaseTx(address, "", 3, fees+1)
+	block := createBlock(append([]*Transaction{greedy}, txs...), Records{}, chain.LastHash, 3, LegacyBits)
+	err := chain.AddBlock(block)
+	assert.Equal(t, RejectCoinbase, err.(*BlockError).Reason)
+
+	block = createBlock(append([]*Transaction{CoinbaseTx(address, "", 3, fees)}, txs...), Records{}, chain.LastHash, 3, LegacyBits)
+	assert.NoError(t, chain.AddBlock(block))
+
+	// Paying out more than the inputs hold is rejected
+	overpay := NewTransaction(a, address, 5, 0, &UTXOSet)
+	overpay.Outputs[0].Value += 100
+	overpay.ID = txID(overpay, BlockVersion)
+	chain.SignTransaction(overpay, a.PrivateKey)
+	_, _, invalid = chain.BlockTemplate([]*Transaction{overpay})
+	assert.Equal(t, []*Transaction{overpay}, invalid)
+	block = createBlock([]*Transaction{overpay}, Records{}, chain.LastHash, 4, LegacyBits)
+	err = chain.AddBlock(block)
+	assert.Equal(t, RejectFee, err.(*BlockError).Reason)
+}
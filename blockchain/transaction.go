@@ -29,9 +29,43 @@ type FileUploadTransaction struct {
 	Filename    string
 	FileHash    string
 	FilePath    string // Optional: relative/absolute path on disk
+	Size        int64
 	Timestamp   int64
+	Scan        ScanResult   // Detection outcome that let the file in
+	Erasure     *ErasureInfo // Set when the file is stored as erasure coded shards
+	ChunkRoot   []byte       // Merkle root over the file's chunks, for storage proofs
+	PubKey      []byte       // Key of the FromAddress wallet
+	Signature   []byte       // Uploader's signature over every other field
 }
 
+// ShardInfo records where one erasure coded shard is kept
+type ShardInfo struct {
+	Index     int
+	Hash      string
+	Node      string
+	Size      int64
+	ChunkRoot []byte
+}
+
+// ErasureInfo describes a k data + m parity Reed-Solomon layout. Any
+// DataShards of the shards are enough to rebuild the Size bytes of the file.
+type ErasureInfo struct {
+	DataShards   int
+	ParityShards int
+	Size         int64
+	Shards       []ShardInfo
+}
+
+// ShardHashes lists the shard hashes in index order
+func (e *ErasureInfo) ShardHashes() []string {
+	hashes := make([]string, len(e.Shards))
+	for _, shard := range e.Shards {
+		hashes[shard.Index] = shard.Hash
+	}
+	return hashes
+}
+
+// Hash is SHA-256 over the canonical encoding with the ID left out
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 	txCopy := *tx
@@ -40,7 +74,27 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
+// legacyHash is Hash as it was computed over gob, which transactions in
+// version 0 blocks are identified by
+func (tx *Transaction) legacyHash() []byte {
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	hash := sha256.Sum256(txCopy.legacySerialize())
+	return hash[:]
+}
+
+// legacy reports whether the transaction's ID is a gob hash
+func (tx *Transaction) legacy() bool {
+	return bytes.Equal(tx.ID, unsigned(tx).legacyHash())
+}
+
 func (tx Transaction) Serialize() []byte {
+	e := newEncoder()
+	e.transaction(&tx)
+	return e.buf
+}
+
+func (tx Transaction) legacySerialize() []byte {
 	var encoded bytes.Buffer
 	enc := gob.NewEncoder(&encoded)
 	err := enc.Encode(tx)
@@ -51,16 +105,21 @@ func (tx Transaction) Serialize() []byte {
 }
 
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
-	if err != nil {
-		log.Panic(err)
-	}
-	return transaction
+	tx, err := DecodeTransaction(data)
+	Handle(err)
+	return tx
+}
+
+// DecodeTransaction is DeserializeTransaction for untrusted input
+func DecodeTransaction(data []byte) (Transaction, error) {
+	d := newDecoder(data)
+	tx := d.transaction()
+	return *tx, d.finish()
 }
 
-func CoinbaseTx(to, data string) *Transaction {
+// CoinbaseTx pays the subsidy of the block at height and the fees of its
+// transactions to the miner
+func CoinbaseTx(to, data string, height, fees int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 24)
 		_, err := rand.Read(randData)
@@ -71,21 +130,22 @@ func CoinbaseTx(to, data string) *Transaction {
 	}
 
 	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(20, to)
+	txout := NewTXOutput(Params.Subsidy(height)+fees, to)
 	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+// NewTransaction pays amount to the address and leaves fee to the miner
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("Error: not enough funds")
 	}
 
@@ -103,8 +163,8 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 	from := fmt.Sprintf("%s", w.Address())
 
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if change := acc - amount - fee; change > 0 {
+		outputs = append(outputs, *NewTXOutput(change, from))
 	}
 
 	tx := Transaction{nil, inputs, outputs}
@@ -114,7 +174,22 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 	return &tx
 }
 
-func NewFileUploadTransaction(fromAddress string, filename string, fileData []byte, storagePath string) *FileUploadTransaction {
+// NewTransactionAtRate is NewTransaction with the fee worked out from a rate
+// per 1000 bytes. More inputs make the transaction bigger, so the fee is
+// recomputed until it covers the size.
+func NewTransactionAtRate(w *wallet.Wallet, to string, amount, rate int, UTXO *UTXOSet) *Transaction {
+	fee := 0
+	for {
+		tx := NewTransaction(w, to, amount, fee, UTXO)
+		needed := (rate*tx.Size() + 999) / 1000
+		if needed <= fee {
+			return tx
+		}
+		fee = needed
+	}
+}
+
+func NewFileUploadTransaction(fromAddress string, filename string, fileData []byte, storagePath string, scan ScanResult) *FileUploadTransaction {
 	hash := sha256.Sum256(fileData)
 
 	return &FileUploadTransaction{
@@ -122,10 +197,94 @@ func NewFileUploadTransaction(fromAddress string, filename string, fileData []by
 		Filename:    filename,
 		FileHash:    hex.EncodeToString(hash[:]),
 		FilePath:    storagePath,
+		Size:        int64(len(fileData)),
 		Timestamp:   time.Now().Unix(),
+		Scan:        scan,
 	}
 }
 
+// Hash covers every field of the file transaction, scan result included
+func (tx *FileUploadTransaction) Hash() []byte {
+	return tx.hashAt(BlockVersion)
+}
+
+// hashAt is Hash as committed by blocks of the given version
+func (tx *FileUploadTransaction) hashAt(version int) []byte {
+	if version == 0 {
+		return tx.legacyHash()
+	}
+	e := newEncoder()
+	e.fileTx(tx)
+	hash := sha256.Sum256(e.buf)
+	return hash[:]
+}
+
+// Sign attaches the key and signature of w, the wallet of FromAddress
+func (tx *FileUploadTransaction) Sign(w *wallet.Wallet) {
+	tx.PubKey = w.PublicKey
+	tx.Signature = signHash(w, tx.signingHash())
+}
+
+func (tx *FileUploadTransaction) signingHash() []byte {
+	txCopy := *tx
+	txCopy.Signature = nil
+	return txCopy.Hash()
+}
+
+// Verify checks the uploader named in FromAddress signed the transaction,
+// so neither the sender nor the scan verdict can be swapped on the way
+func (tx *FileUploadTransaction) Verify() bool {
+	return keyAddress(tx.PubKey) == tx.FromAddress && verifyHash(tx.PubKey, tx.signingHash(), tx.Signature)
+}
+
+// legacyHash is Hash over gob, as committed by version 0 blocks
+func (tx *FileUploadTransaction) legacyHash() []byte {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+func (tx FileUploadTransaction) Serialize() []byte {
+	e := newEncoder()
+	e.fileTx(&tx)
+	return e.buf
+}
+
+func DeserializeFileTransaction(data []byte) FileUploadTransaction {
+	tx, err := DecodeFileTransaction(data)
+	Handle(err)
+	return tx
+}
+
+// DecodeFileTransaction is DeserializeFileTransaction for untrusted input
+func DecodeFileTransaction(data []byte) (FileUploadTransaction, error) {
+	d := newDecoder(data)
+	tx := d.fileTx()
+	return *tx, d.finish()
+}
+
+func (tx FileUploadTransaction) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("--- File %s:", tx.FileHash))
+	lines = append(lines, fmt.Sprintf("     Filename:  %s", tx.Filename))
+	lines = append(lines, fmt.Sprintf("     From:      %s", tx.FromAddress))
+	lines = append(lines, fmt.Sprintf("     Path:      %s", tx.FilePath))
+	lines = append(lines, fmt.Sprintf("     Timestamp: %d", tx.Timestamp))
+	if tx.Erasure != nil {
+		lines = append(lines, fmt.Sprintf("     Erasure:   %d data + %d parity shards", tx.Erasure.DataShards, tx.Erasure.ParityShards))
+		for _, shard := range tx.Erasure.Shards {
+			lines = append(lines, fmt.Sprintf("       Shard %d: %s on %s", shard.Index, shard.Hash, shard.Node))
+		}
+	}
+	lines = append(lines, tx.Scan.String())
+	return strings.Join(lines, "\n")
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
@@ -142,6 +301,7 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 	}
 
 	txCopy := tx.TrimmedCopy()
+	legacy := tx.legacy()
 
 	for inId, in := range txCopy.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
@@ -149,6 +309,9 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
 
 		dataToSign := txCopy.Hash()
+		if legacy {
+			dataToSign = txCopy.legacyHash()
+		}
 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, dataToSign)
 		if err != nil {
 			log.Panic(err)
@@ -171,6 +334,7 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	}
 
 	txCopy := tx.TrimmedCopy()
+	legacy := tx.legacy()
 	curve := elliptic.P256()
 
 	for inId, in := range tx.Inputs {
@@ -178,6 +342,9 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		txCopy.Inputs[inId].Signature = nil
 		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
 		txHash := txCopy.Hash()
+		if legacy {
+			txHash = txCopy.legacyHash()
+		}
 
 		r := big.Int{}
 		s := big.Int{}
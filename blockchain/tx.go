@@ -2,7 +2,6 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
 
 	"github.com/rudrasantadip/ransumgo/wallet"
 )
@@ -45,17 +44,14 @@ func NewTXOutput(value int, address string) *TxOutput {
 }
 
 func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	Handle(err)
-	return buffer.Bytes()
+	e := newEncoder()
+	e.outputs(outs.Outputs)
+	return e.buf
 }
 
 func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	Handle(err)
+	d := newDecoder(data)
+	outputs := TxOutputs{d.outputs()}
+	Handle(d.finish())
 	return outputs
 }
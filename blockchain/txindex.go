@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	txIndexPrefix = []byte("txidx-")
+	txIndexFlag   = []byte("txindex")
+)
+
+// TxLocation is where a transaction sits on the main chain
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func txIndexKey(id []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), id...)
+}
+
+// TxIndexEnabled reports whether the transaction index is kept up to date.
+// It is switched on by ReindexTransactions.
+func (chain *BlockChain) TxIndexEnabled() bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(txIndexFlag)
+		return err
+	})
+	return err == nil
+}
+
+// ReindexTransactions rebuilds the transaction index from the main chain
+// and keeps it enabled from then on. It returns the number of indexed
+// transactions.
+func (chain *BlockChain) ReindexTransactions() int {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	chain.DropTxIndex()
+
+	count := 0
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		chain.indexTransactions(block, true)
+		count += len(block.Transactions)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(txIndexFlag, []byte{1})
+	})
+	Handle(err)
+	return count
+}
+
+// DropTxIndex deletes the transaction index and stops maintaining it
+func (chain *BlockChain) DropTxIndex() {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txIndexFlag)
+	})
+	Handle(err)
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.DeleteByPrefix(txIndexPrefix)
+}
+
+// indexTransactions adds the block's transactions to the index when it is
+// connected and removes them when it is disconnected
+func (chain *BlockChain) indexTransactions(block *Block, connect bool) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for pos, tx := range block.Transactions {
+			if !connect {
+				if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+					return err
+				}
+				continue
+			}
+			value := binary.BigEndian.AppendUint32(append([]byte{}, block.Hash...), uint32(pos))
+			if err := txn.Set(txIndexKey(tx.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	Handle(err)
+}
+
+// LookupTransaction finds a main chain transaction through the index
+func (chain *BlockChain) LookupTransaction(id []byte) (TxLocation, error) {
+	var location TxLocation
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txIndexKey(id))
+		if err == badger.ErrKeyNotFound {
+			return errors.New("Transaction does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		location.BlockHash = value[:len(value)-4]
+		location.Position = int(binary.BigEndian.Uint32(value[len(value)-4:]))
+		return nil
+	})
+	return location, err
+}
+
+// GetTransaction returns a main chain transaction with the block holding it
+// and its number of confirmations
+func (chain *BlockChain) GetTransaction(id []byte) (Transaction, Block, int, error) {
+	var block Block
+	if chain.TxIndexEnabled() {
+		location, err := chain.LookupTransaction(id)
+		if err != nil {
+			return Transaction{}, block, 0, err
+		}
+		block, err = chain.GetBlock(location.BlockHash)
+		if err != nil {
+			return Transaction{}, block, 0, err
+		}
+		if location.Position >= len(block.Transactions) {
+			return Transaction{}, block, 0, fmt.Errorf("transaction index is out of date for %x", id)
+		}
+		tx := block.Transactions[location.Position]
+		return *tx, block, chain.GetBestHeight() - block.Height + 1, nil
+	}
+
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return *tx, *block, chain.GetBestHeight() - block.Height + 1, nil
+			}
+		}
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return Transaction{}, block, 0, errors.New("Transaction does not exist")
+}
@@ -2,7 +2,9 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/dgraph-io/badger"
@@ -90,6 +92,40 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 	return UTXOs
 }
 
+// Fee is what tx leaves to the miner, read from the outputs it spends. It
+// fails when one of them is not in the set.
+func (u UTXOSet) Fee(tx *Transaction) (int, error) {
+	fee := 0
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		for _, in := range tx.Inputs {
+			item, err := txn.Get(append(utxoPrefix, in.ID...))
+			if err != nil {
+				return err
+			}
+			var outs TxOutputs
+			err = item.Value(func(val []byte) error {
+				outs = DeserializeOutputs(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if in.Out < 0 || in.Out >= len(outs.Outputs) {
+				return fmt.Errorf("output %x:%d is not unspent", in.ID, in.Out)
+			}
+			fee += outs.Outputs[in.Out].Value
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+	return fee, nil
+}
+
 func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.Database
 	counter := 0
@@ -133,16 +169,61 @@ func (u UTXOSet) Reindex() {
 	Handle(err)
 }
 
+// utxoUndo is the value a UTXO key held before a block touched it
+type utxoUndo struct {
+	Key     []byte
+	Value   []byte
+	Existed bool
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append([]byte("undo-"), blockHash...)
+}
+
+// loadUndo reads the undo data Update stored for a block
+func loadUndo(txn *badger.Txn, blockHash []byte) ([]utxoUndo, error) {
+	item, err := txn.Get(undoKey(blockHash))
+	if err != nil {
+		return nil, err
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	var undo []utxoUndo
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+	return undo, err
+}
+
+// Update applies the block to the UTXO set and keeps undo data so the
+// block can be disconnected again with Rewind
 func (u *UTXOSet) Update(block *Block) {
 	db := u.Blockchain.Database
 	var err error
 	var v []byte
 	err = db.Update(func(txn *badger.Txn) error {
+		var undo []utxoUndo
+		touched := make(map[string]bool)
+		remember := func(key []byte) {
+			if touched[string(key)] {
+				return
+			}
+			touched[string(key)] = true
+			entry := utxoUndo{Key: key}
+			if item, err := txn.Get(key); err == nil {
+				entry.Value, err = item.ValueCopy(nil)
+				Handle(err)
+				entry.Existed = true
+			}
+			undo = append(undo, entry)
+		}
+
 		for _, tx := range block.Transactions {
 			if tx.IsCoinbase() == false {
 				for _, in := range tx.Inputs {
 					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
+					inID := append(append([]byte{}, utxoPrefix...), in.ID...)
+					remember(inID)
 					item, err := txn.Get(inID)
 					Handle(err)
 					err = item.Value(func(val []byte) error {
@@ -153,13 +234,18 @@ func (u *UTXOSet) Update(block *Block) {
 
 					outs := DeserializeOutputs(v)
 
+					// Spent outputs stay behind as empty placeholders so the
+					// remaining ones keep their output index
+					unspent := false
 					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						if outIdx == in.Out {
+							out = TxOutput{}
 						}
+						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						unspent = unspent || out.PubKeyHash != nil
 					}
 
-					if len(updatedOuts.Outputs) == 0 {
+					if !unspent {
 						if err := txn.Delete(inID); err != nil {
 							log.Panic(err)
 						}
@@ -175,17 +261,51 @@ func (u *UTXOSet) Update(block *Block) {
 				newOutputs.Outputs = append(newOutputs.Outputs, out)
 			}
 
-			txID := append(utxoPrefix, tx.ID...)
+			txID := append(append([]byte{}, utxoPrefix...), tx.ID...)
+			remember(txID)
 			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
 				log.Panic(err)
 			}
 		}
 
-		return nil
+		var encoded bytes.Buffer
+		err := gob.NewEncoder(&encoded).Encode(undo)
+		Handle(err)
+		return txn.Set(undoKey(block.Hash), encoded.Bytes())
 	})
 	Handle(err)
 }
 
+// Rewind disconnects a block from the UTXO set using the undo data that
+// Update stored for it. Blocks connected before undo data existed return an
+// error and need a full Reindex instead.
+func (u *UTXOSet) Rewind(block *Block) error {
+	db := u.Blockchain.Database
+	return db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(undoKey(block.Hash))
+		if err == badger.ErrKeyNotFound {
+			return fmt.Errorf("no undo data for block %x", block.Hash)
+		}
+		Handle(err)
+		data, err := item.ValueCopy(nil)
+		Handle(err)
+
+		var undo []utxoUndo
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+		Handle(err)
+
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undo[i].Existed {
+				err = txn.Set(undo[i].Key, undo[i].Value)
+			} else {
+				err = txn.Delete(undo[i].Key)
+			}
+			Handle(err)
+		}
+		return txn.Delete(undoKey(block.Hash))
+	})
+}
+
 func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 	deleteKeys := func(keysForDelete [][]byte) error {
 		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
@@ -0,0 +1,394 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// RejectReason says which consensus rule a block broke
+type RejectReason string
+
+const (
+	RejectVersion      RejectReason = "bad-version"
+	RejectHash         RejectReason = "bad-hash"
+	RejectProofOfWork  RejectReason = "bad-pow"
+	RejectMerkleRoot   RejectReason = "bad-merkle-root"
+	RejectPrevLink     RejectReason = "bad-prev-link"
+	RejectDifficulty   RejectReason = "bad-difficulty"
+	RejectSigner       RejectReason = "bad-signer"
+	RejectSignature    RejectReason = "bad-signature"
+	RejectMissingInput RejectReason = "missing-input"
+	RejectDoubleSpend  RejectReason = "double-spend"
+	RejectCoinbase     RejectReason = "bad-coinbase"
+	RejectFee          RejectReason = "bad-fee"
+	RejectRecords      RejectReason = "bad-records"
+	RejectTimestamp    RejectReason = "bad-timestamp"
+)
+
+const (
+	// medianTimeBlocks is how many blocks the median time past is taken over
+	medianTimeBlocks = 11
+)
+
+// BlockError is returned for a block that fails validation
+type BlockError struct {
+	Hash   []byte
+	Reason RejectReason
+	Detail string
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %x rejected (%s): %s", e.Hash, e.Reason, e.Detail)
+}
+
+func reject(block *Block, reason RejectReason, format string, args ...interface{}) *BlockError {
+	return &BlockError{block.Hash, reason, fmt.Sprintf(format, args...)}
+}
+
+// CheckBlock runs the checks that need nothing but the block itself and the
+// clock: the version is known and current enough for the height, the
+// timestamp is not too far ahead, the Merkle root and the record signatures
+func CheckBlock(block *Block) error {
+	if block.Version < 0 || block.Version > BlockVersion {
+		return reject(block, RejectVersion, "unknown block version %d", block.Version)
+	}
+	// Version 0 headers leave fields out of the seal, so they are only
+	// taken where the chain already had them
+	if block.Height >= Params.UpgradeHeight && block.Version == 0 {
+		return reject(block, RejectVersion, "version 0 is below %d, required from height %d", BlockVersion, Params.UpgradeHeight)
+	}
+	if limit := time.Now().Unix() + Params.MaxFutureDrift; block.Timestamp > limit {
+		return reject(block, RejectTimestamp, "timestamp %d is more than %ds in the future", block.Timestamp, Params.MaxFutureDrift)
+	}
+	// Only blocks from before the root was stored in the header may omit it
+	if block.Version > 0 && len(block.MerkleRoot) == 0 {
+		return reject(block, RejectMerkleRoot, "missing Merkle root")
+	}
+	if len(block.MerkleRoot) > 0 && !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return reject(block, RejectMerkleRoot, "Merkle root does not match the contents")
+	}
+	if err := block.Records.verify(block.Version); err != nil {
+		return reject(block, RejectRecords, "%s", err)
+	}
+	return nil
+}
+
+// ValidateBlock checks a block against the branch it extends: its seal
+// under the consensus engine and its transactions. Its parent must already
+// be stored; the spent outputs are taken from that branch, so blocks on side
+// chains are validated just like blocks on the tip.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if err := CheckBlock(block); err != nil {
+		return err
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return reject(block, RejectPrevLink, "previous block %x is unknown", block.PrevHash)
+	}
+	if block.Height != parent.Height+1 {
+		return reject(block, RejectPrevLink, "height %d does not follow %d", block.Height, parent.Height)
+	}
+	if block.Version < parent.Version {
+		return reject(block, RejectVersion, "version %d follows version %d", block.Version, parent.Version)
+	}
+	if block.Version > 0 {
+		if median := chain.MedianTimePast(&parent); block.Timestamp <= median {
+			return reject(block, RejectTimestamp, "timestamp %d is not after the median time past %d", block.Timestamp, median)
+		}
+	}
+	if err := chain.engine().VerifySeal(chain, &parent, block); err != nil {
+		return err
+	}
+
+	view := chain.branchView(parent.Hash)
+	defer view.discard()
+	var coinbase *Transaction
+	fees := 0
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, txID(tx, block.Version)) {
+			return reject(block, RejectSignature, "transaction %x has a wrong id", tx.ID)
+		}
+		if tx.IsCoinbase() {
+			if coinbase != nil {
+				return reject(block, RejectCoinbase, "more than one coinbase")
+			}
+			coinbase = tx
+		} else {
+			fee, err := view.spend(tx)
+			if err != nil {
+				err.Hash = block.Hash
+				return err
+			}
+			fees += fee
+		}
+		view.add(tx)
+	}
+
+	// The miner may claim the subsidy and the fees of the block, no more
+	if coinbase != nil {
+		value := 0
+		for _, out := range coinbase.Outputs {
+			if out.Value < 0 {
+				return reject(block, RejectCoinbase, "coinbase %x has a negative output", coinbase.ID)
+			}
+			value += out.Value
+		}
+		if value > Params.Subsidy(block.Height)+fees {
+			return reject(block, RejectCoinbase, "coinbase %x pays %d with %d in fees", coinbase.ID, value, fees)
+		}
+	}
+	return nil
+}
+
+// MedianTimePast is the median timestamp of block and the ancestors before
+// it, medianTimeBlocks in all. A new block must be later, so a miner cannot
+// move the chain's time backwards however its own clock is set.
+func (chain *BlockChain) MedianTimePast(block *Block) int64 {
+	times := []int64{block.Timestamp}
+	for len(times) < medianTimeBlocks && len(block.PrevHash) > 0 {
+		block = chain.parent(block)
+		times = append(times, block.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// txID is the hash a transaction was given before its inputs were signed,
+// by the rules of the block version it goes in
+func txID(tx *Transaction, version int) []byte {
+	if version == 0 {
+		return unsigned(tx).legacyHash()
+	}
+	return unsigned(tx).Hash()
+}
+
+// unsigned copies tx without its input signatures
+func unsigned(tx *Transaction) *Transaction {
+	txCopy := *tx
+	txCopy.Inputs = make([]TxInput, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		in.Signature = nil
+		txCopy.Inputs[i] = in
+	}
+	return &txCopy
+}
+
+// utxoView is the UTXO set as of a block on any branch. It reads the main
+// chain's set from one database snapshot and overlays the changes that
+// lead from the main tip to the block: the main chain blocks above the fork
+// point undone, then the branch's own blocks applied. Only the blocks off
+// the common part are visited, not the chain from genesis.
+type utxoView struct {
+	txn     *badger.Txn
+	changed map[string]*TxOutputs // Outputs by transaction ID that differ from the stored set, nil when gone
+	spent   map[string]bool       // Outputs spent by the blocks applied to the view
+	replay  bool                  // The view was rebuilt from genesis and ignores the stored set
+}
+
+// branchView is the UTXO set at tip. Call discard when done with it.
+func (chain *BlockChain) branchView(tip []byte) *utxoView {
+	view := &utxoView{
+		txn:     chain.Database.NewTransaction(false),
+		changed: make(map[string]*TxOutputs),
+		spent:   make(map[string]bool),
+	}
+
+	item, err := view.txn.Get([]byte("lh"))
+	Handle(err)
+	lastHash, err := item.ValueCopy(nil)
+	Handle(err)
+	mainTip, err := view.block(lastHash)
+	Handle(err)
+	block, err := view.block(tip)
+	Handle(err)
+
+	// Walk back from tip to the main chain
+	var branch []*Block
+	for !view.onMainChain(block) {
+		if len(block.PrevHash) == 0 {
+			return view.rebuild(branch, block)
+		}
+		branch = append(branch, block)
+		block, err = view.block(block.PrevHash)
+		Handle(err)
+	}
+
+	// Undo the main chain down to the fork point, newest first so the
+	// oldest undo data of a key is the one left standing
+	for height := mainTip.Height; height > block.Height; height-- {
+		hash, err := view.mainHash(height)
+		Handle(err)
+		undo, err := view.undo(hash)
+		if err != nil {
+			// Blocks connected before undo data was kept
+			full, err := view.block(tip)
+			Handle(err)
+			return view.rebuild(nil, full)
+		}
+		for _, entry := range undo {
+			id := hex.EncodeToString(bytes.TrimPrefix(entry.Key, utxoPrefix))
+			if !entry.Existed {
+				view.changed[id] = nil
+				continue
+			}
+			outs := DeserializeOutputs(entry.Value)
+			view.changed[id] = &outs
+		}
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		for _, tx := range branch[i].Transactions {
+			view.add(tx)
+		}
+	}
+	return view
+}
+
+// rebuild replays the whole branch under tip into an empty view, for
+// chains whose main chain lacks the undo data or height index to rewind.
+// branch holds the blocks already read, newest first.
+func (view *utxoView) rebuild(branch []*Block, tip *Block) *utxoView {
+	view.changed = make(map[string]*TxOutputs)
+	view.spent = make(map[string]bool)
+	view.replay = true
+
+	block := tip
+	for {
+		branch = append(branch, block)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+		var err error
+		block, err = view.block(block.PrevHash)
+		Handle(err)
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		for _, tx := range branch[i].Transactions {
+			view.add(tx)
+		}
+	}
+	return view
+}
+
+func (view *utxoView) discard() {
+	view.txn.Discard()
+}
+
+func (view *utxoView) block(hash []byte) (*Block, error) {
+	item, err := view.txn.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	var block *Block
+	err = item.Value(func(val []byte) error {
+		block = Deserialize(val)
+		return nil
+	})
+	return block, err
+}
+
+func (view *utxoView) mainHash(height int) ([]byte, error) {
+	item, err := view.txn.Get(heightKey(height))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (view *utxoView) onMainChain(block *Block) bool {
+	hash, err := view.mainHash(block.Height)
+	return err == nil && bytes.Equal(hash, block.Hash)
+}
+
+func (view *utxoView) undo(blockHash []byte) ([]utxoUndo, error) {
+	return loadUndo(view.txn, blockHash)
+}
+
+// outputs are the outputs of the transaction as of the view, spent ones
+// left as empty placeholders, or nil when none are left
+func (view *utxoView) outputs(id []byte) *TxOutputs {
+	key := hex.EncodeToString(id)
+	if outs, ok := view.changed[key]; ok || view.replay {
+		return outs
+	}
+	item, err := view.txn.Get(append(append([]byte{}, utxoPrefix...), id...))
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	Handle(err)
+	var outs TxOutputs
+	err = item.Value(func(val []byte) error {
+		outs = DeserializeOutputs(val)
+		return nil
+	})
+	Handle(err)
+	return &outs
+}
+
+func outpoint(id []byte, out int) string {
+	return fmt.Sprintf("%x:%d", id, out)
+}
+
+// add applies tx to the view the way UTXOSet.Update applies it to the set
+func (view *utxoView) add(tx *Transaction) {
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			view.spent[outpoint(in.ID, in.Out)] = true
+			outs := view.outputs(in.ID)
+			if outs == nil || in.Out < 0 || in.Out >= len(outs.Outputs) {
+				continue
+			}
+			updated := TxOutputs{Outputs: append([]TxOutput{}, outs.Outputs...)}
+			updated.Outputs[in.Out] = TxOutput{}
+			view.changed[hex.EncodeToString(in.ID)] = &updated
+		}
+	}
+	view.changed[hex.EncodeToString(tx.ID)] = &TxOutputs{Outputs: append([]TxOutput{}, tx.Outputs...)}
+}
+
+// spend checks that every input of tx refers to an unspent output locked
+// to the key that signed it, and that the inputs cover the outputs. The
+// difference is the fee left to the miner.
+func (view *utxoView) spend(tx *Transaction) (int, *BlockError) {
+	prevTXs := make(map[string]Transaction)
+	seen := make(map[string]bool)
+	fee := 0
+	for _, in := range tx.Inputs {
+		if view.spent[outpoint(in.ID, in.Out)] || seen[outpoint(in.ID, in.Out)] {
+			return 0, &BlockError{Reason: RejectDoubleSpend, Detail: fmt.Sprintf("transaction %x spends %s twice", tx.ID, outpoint(in.ID, in.Out))}
+		}
+		outs := view.outputs(in.ID)
+		if outs == nil || in.Out < 0 || in.Out >= len(outs.Outputs) {
+			return 0, &BlockError{Reason: RejectMissingInput, Detail: fmt.Sprintf("transaction %x spends unknown output %s", tx.ID, outpoint(in.ID, in.Out))}
+		}
+		out := outs.Outputs[in.Out]
+		if out.PubKeyHash == nil {
+			return 0, &BlockError{Reason: RejectDoubleSpend, Detail: fmt.Sprintf("transaction %x spends %s, which is already spent", tx.ID, outpoint(in.ID, in.Out))}
+		}
+		if !in.UsesKey(out.PubKeyHash) {
+			return 0, &BlockError{Reason: RejectSignature, Detail: fmt.Sprintf("transaction %x spends an output it does not own", tx.ID)}
+		}
+		seen[outpoint(in.ID, in.Out)] = true
+		prevTXs[hex.EncodeToString(in.ID)] = Transaction{ID: in.ID, Outputs: outs.Outputs}
+		fee += out.Value
+	}
+	for _, out := range tx.Outputs {
+		if out.Value < 0 {
+			return 0, &BlockError{Reason: RejectFee, Detail: fmt.Sprintf("transaction %x has a negative output", tx.ID)}
+		}
+		fee -= out.Value
+	}
+	if fee < 0 {
+		return 0, &BlockError{Reason: RejectFee, Detail: fmt.Sprintf("transaction %x pays out %d more than it spends", tx.ID, -fee)}
+	}
+	if !tx.Verify(prevTXs) {
+		return 0, &BlockError{Reason: RejectSignature, Detail: fmt.Sprintf("transaction %x has an invalid signature", tx.ID)}
+	}
+	return fee, nil
+}
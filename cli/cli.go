@@ -1,15 +1,23 @@
 package cli
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/detection"
+	"github.com/rudrasantadip/ransumgo/incident"
 	"github.com/rudrasantadip/ransumgo/network"
+	"github.com/rudrasantadip/ransumgo/snapshot"
+	"github.com/rudrasantadip/ransumgo/storage"
 	"github.com/rudrasantadip/ransumgo/wallet"
 )
 
@@ -19,12 +27,31 @@ func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
 	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println("   The genesis, consensus engine and chain rules are read from the JSON file in CHAIN_PARAMS env. var. if set; -address may be left out when it allocates coins")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins. Then -mine flag is set, mine off of this node")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE -mine - Send amount of coins and leave fee to the miner, estimated when -fee is not set. Then -mine flag is set, mine off of this node")
+	fmt.Println(" estimatefee -blocks N - Prints the fee per 1000 bytes that has confirmed within N blocks")
 	fmt.Println(" createwallet - Creates a new Wallet")
-	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+	fmt.Println(" listaddresses -keys - Lists the addresses in our wallet file, -keys also prints their public keys")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
-	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println(" reindextx -drop - Builds and enables the transaction index, -drop removes it again")
+	fmt.Println(" addresshistory -address ADDRESS - List every transaction that paid or spent from an address")
+	fmt.Println(" gettx -id TXID - Print a transaction with its block and number of confirmations")
+	fmt.Println(" blocklist -from ADDRESS -add HASH -reason REASON -mine - Add a SHA-256 file hash to the network blocklist, -mine records it on this node")
+	fmt.Println(" blocklist -from ADDRESS -remove HASH -mine - Remove a SHA-256 file hash from the network blocklist")
+	fmt.Println(" listblocklist - Lists the file hashes currently on the blocklist")
+	fmt.Println(" votevalidator -from ADDRESS -add ADDRESS -mine - Vote to add a proof-of-authority validator, -mine seals the vote on this node")
+	fmt.Println(" votevalidator -from ADDRESS -remove ADDRESS -mine - Vote to remove a proof-of-authority validator")
+	fmt.Println(" listvalidators - Lists the validators that take turns sealing blocks")
+	fmt.Println(" supply - Prints the coins issued so far, how many remain under the cap and the next block subsidy")
+	fmt.Println(" incidentreport -from TIME -to TIME -out DIR - Export detection events and chain evidence as JSON and HTML")
+	fmt.Println(" plantcanary -dir DIR - Plant a decoy file in DIR that raises a canary alert when touched")
+	fmt.Println(" trainclassifier -benign DIR -encrypted DIR - Train the upload classifier from local sample folders")
+	fmt.Println(" snapshot -dir PATH -from ADDRESS - Anchor the Merkle root of a directory tree on-chain")
+	fmt.Println(" restoresnapshot -dir PATH -root ROOT -restore - Diff a directory against a snapshot, -restore writes changed files back")
+	fmt.Println(" getblock -height N -to M - Print the main chain block at height N, or every block from N to M")
+	fmt.Println(" rebuildfile -hash HASH -out PATH - Rebuild an uploaded file from the local object store, decoding erasure coded shards")
+	fmt.Println(" startnode -miner ADDRESS -replicas N - Start a node with ID specified in NODE_ID env. var. -miner enables mining, -replicas sets how many nodes keep each file")
 }
 
 func (cli *CommandLine) validateArgs() {
@@ -57,11 +84,65 @@ func (cli *CommandLine) reindexUTXO(nodeID string) {
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CommandLine) listAddresses(nodeID string) {
+func (cli *CommandLine) reindexTransactions(drop bool, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	if drop {
+		chain.DropTxIndex()
+		fmt.Println("Transaction index removed")
+		return
+	}
+	count := chain.ReindexTransactions()
+	fmt.Printf("Done! Indexed %d transactions.\n", count)
+}
+
+func (cli *CommandLine) getTransaction(id, nodeID string) {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		log.Panic(err)
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	tx, block, confirmations, err := chain.GetTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(tx)
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Confirmations: %d\n", confirmations)
+}
+
+func (cli *CommandLine) addressHistory(address, nodeID string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	history := chain.AddressHistory(pubKeyHash)
+
+	for _, entry := range history.Entries {
+		fmt.Printf("Height %d  tx %x  received %d  sent %d\n", entry.Height, entry.TxID, entry.Received, entry.Sent)
+	}
+	fmt.Printf("Total received: %d\n", history.Received)
+	fmt.Printf("Total sent: %d\n", history.Sent)
+	fmt.Printf("Balance: %d\n", history.Balance)
+}
+
+func (cli *CommandLine) listAddresses(keys bool, nodeID string) {
 	wallets, _ := wallet.CreateWallets(nodeID)
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
+		if keys {
+			fmt.Printf("%s  %x\n", address, wallets.Wallets[address].PublicKey)
+			continue
+		}
 		fmt.Println(address)
 	}
 
@@ -90,6 +171,15 @@ func (cli *CommandLine) printChain(nodeID string) {
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
+		for _, tx := range block.FileTxs {
+			fmt.Println(tx)
+		}
+		for _, tx := range block.BlocklistTxs {
+			fmt.Println(tx)
+		}
+		for _, tx := range block.SnapshotTxs {
+			fmt.Println(tx)
+		}
 		fmt.Println()
 
 		if len(block.PrevHash) == 0 {
@@ -98,9 +188,62 @@ func (cli *CommandLine) printChain(nodeID string) {
 	}
 }
 
+// printBlock writes a block in the same layout as printchain
+func printBlock(w io.Writer, block *blockchain.Block) {
+	fmt.Fprintf(w, "Height: %d\n", block.Height)
+	fmt.Fprintf(w, "Hash: %x\n", block.Hash)
+	fmt.Fprintf(w, "Prev. hash: %x\n", block.PrevHash)
+	if len(block.Signer) > 0 {
+		fmt.Fprintf(w, "Signer: %s\n", (&wallet.Wallet{PublicKey: block.Signer}).Address())
+	} else {
+		fmt.Fprintf(w, "Bits: %08x\n", blockchain.EffectiveBits(block))
+		pow := blockchain.NewProof(block)
+		fmt.Fprintf(w, "PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	}
+	for _, tx := range block.Transactions {
+		fmt.Fprintln(w, tx)
+	}
+	for _, tx := range block.FileTxs {
+		fmt.Fprintln(w, tx)
+	}
+	for _, tx := range block.BlocklistTxs {
+		fmt.Fprintln(w, tx)
+	}
+	for _, tx := range block.SnapshotTxs {
+		fmt.Fprintln(w, tx)
+	}
+	for _, vote := range block.VoteTxs {
+		fmt.Fprintln(w, vote)
+	}
+	fmt.Fprintln(w)
+}
+
+func (cli *CommandLine) getBlock(height, to int, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	if to < height {
+		to = height
+	}
+	blocks, err := chain.GetBlocksByHeight(height, to)
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(blocks) == 0 {
+		fmt.Printf("No block at height %d, best height is %d\n", height, chain.GetBestHeight())
+		return
+	}
+	for i := range blocks {
+		printBlock(os.Stdout, &blocks[i])
+	}
+}
+
 func (cli *CommandLine) createBlockChain(address, nodeID string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+	// Chains with genesis allocations need no address for the reward
+	if address != "" || len(blockchain.Params.Allocations) == 0 {
+		if !wallet.ValidateAddress(address) {
+			log.Panic("Address is not Valid")
+		}
 	}
 	chain := blockchain.InitBlockChain(address, nodeID)
 	defer chain.Database.Close()
@@ -131,7 +274,7 @@ func (cli *CommandLine) getBalance(address, nodeID string) {
 	fmt.Printf("Balance of %s: %d\n", address, balance)
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CommandLine) send(from, to string, amount, fee int, nodeID string, mineNow bool) {
 	if !wallet.ValidateAddress(to) {
 		log.Panic("Address is not Valid")
 	}
@@ -148,12 +291,27 @@ func (cli *CommandLine) send(from, to string, amount int, nodeID string, mineNow
 	}
 	wallet := wallets.GetWallet(from)
 
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+	var tx *blockchain.Transaction
+	if fee < 0 {
+		tx = blockchain.NewTransactionAtRate(&wallet, to, amount, estimateFeeRate(chain), &UTXOSet)
+		fee, err = UTXOSet.Fee(tx)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Paying a fee of %d\n", fee)
+	} else {
+		tx = blockchain.NewTransaction(&wallet, to, amount, fee, &UTXOSet)
+	}
 	if mineNow {
-		cbTx := blockchain.CoinbaseTx(from, "")
+		cbTx := blockchain.CoinbaseTx(from, "", chain.GetBestHeight()+1, fee)
 		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+		if err := chain.UseSigner(&wallet); err != nil {
+			log.Panic(err)
+		}
+		_, err := chain.MineBlock(txs, blockchain.Records{})
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
 		network.SendTx(network.KnownNodes[0], tx)
 		fmt.Println("send tx")
@@ -162,6 +320,374 @@ func (cli *CommandLine) send(from, to string, amount int, nodeID string, mineNow
 	fmt.Println("Success!")
 }
 
+// estimateFeeRate is the rate the wallet pays when no fee is given, none
+// until the node has seen enough transactions confirm
+func estimateFeeRate(chain *blockchain.BlockChain) int {
+	rate, err := chain.EstimateFee(blockchain.DefaultFeeTarget)
+	if err != nil {
+		return 0
+	}
+	return rate
+}
+
+func (cli *CommandLine) estimateFee(blocks int, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	rate, err := chain.EstimateFee(blocks)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Fee rate to confirm within %d blocks: %d per 1000 bytes\n", blocks, rate)
+}
+
+func (cli *CommandLine) blocklist(from, addHash, removeHash, reason, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is not Valid")
+	}
+	action, fileHash := blockchain.BlocklistAdd, addHash
+	if removeHash != "" {
+		action, fileHash = blockchain.BlocklistRemove, removeHash
+	}
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	w := wallets.GetWallet(from)
+
+	tx, err := blockchain.NewBlocklistTransaction(&w, action, fileHash, reason)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !mineNow {
+		network.SendBlocklistTx(network.KnownNodes[0], tx)
+		fmt.Printf("Blocklist %s for %s sent\n", action, tx.FileHash)
+		return
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	if err := chain.UseSigner(&w); err != nil {
+		log.Panic(err)
+	}
+	err = chain.AddBlocklistBlock(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Blocklist %s recorded for %s\n", action, tx.FileHash)
+}
+
+func (cli *CommandLine) listBlocklist(nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	for _, entry := range chain.Blocklist() {
+		fmt.Printf("%s  %s (added by %s in block %x)\n", entry.FileHash, entry.Reason, entry.AddedBy, entry.BlockHash)
+	}
+}
+
+func (cli *CommandLine) voteValidator(from, addAddress, removeAddress, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is not Valid")
+	}
+	action, address := blockchain.VoteAdd, addAddress
+	if removeAddress != "" {
+		action, address = blockchain.VoteRemove, removeAddress
+	}
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	w := wallets.GetWallet(from)
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	epoch, err := chain.VoteEpoch()
+	if err != nil {
+		log.Panic(err)
+	}
+	vote, err := blockchain.NewValidatorVote(&w, action, address, epoch)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !mineNow {
+		network.SendVote(network.KnownNodes[0], vote)
+		fmt.Printf("Vote to %s %s sent\n", action, address)
+		return
+	}
+
+	if err := chain.UseSigner(&w); err != nil {
+		log.Panic(err)
+	}
+	err = chain.AddVoteBlock(vote)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Vote to %s %s recorded\n", action, address)
+}
+
+func (cli *CommandLine) listValidators(nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	validators, err := chain.Validators()
+	if err != nil {
+		log.Panic(err)
+	}
+	next := blockchain.InTurn(validators, chain.GetBestHeight()+1)
+	for _, address := range validators {
+		if address == next {
+			fmt.Printf("%s (seals the next block)\n", address)
+			continue
+		}
+		fmt.Println(address)
+	}
+}
+
+func (cli *CommandLine) supply(nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	supply := chain.Supply()
+	fmt.Printf("Height: %d\n", supply.Height)
+	fmt.Printf("Issued: %d\n", supply.Issued)
+	if supply.Remaining < 0 {
+		fmt.Println("Remaining: unlimited")
+	} else {
+		fmt.Printf("Remaining: %d of %d\n", supply.Remaining, blockchain.Params.MaxSupply)
+	}
+	fmt.Printf("Next subsidy: %d\n", supply.NextSubsidy)
+}
+
+func (cli *CommandLine) incidentReport(from, to, outDir, nodeID string) {
+	now := time.Now().Unix()
+	fromTs, err := incident.ParseTime(from, now-24*60*60)
+	if err != nil {
+		log.Panic(err)
+	}
+	toTs, err := incident.ParseTime(to, now)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	report, err := incident.BuildReport(chain, nodeID, fromTs, toTs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.MkdirAll(outDir, os.ModePerm)
+	if err != nil {
+		log.Panic(err)
+	}
+	base := filepath.Join(outDir, fmt.Sprintf("incident_%s_%d", nodeID, report.GeneratedAt))
+
+	jsonFile, err := os.Create(base + ".json")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer jsonFile.Close()
+	err = report.WriteJSON(jsonFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	htmlFile, err := os.Create(base + ".html")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer htmlFile.Close()
+	err = report.WriteHTML(htmlFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("%d detections, %d quarantined, %d canary alerts, %d chain records\n",
+		len(report.Detections), len(report.Quarantined), len(report.CanaryAlerts), len(report.ChainRecords))
+	fmt.Printf("Report written to %s.json and %s.html\n", base, base)
+}
+
+func (cli *CommandLine) plantCanary(dir, nodeID string) {
+	canary, err := incident.PlantCanary(nodeID, dir)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Canary planted at %s\n", canary.Path)
+}
+
+func (cli *CommandLine) trainClassifier(benignDir, encryptedDir string, epochs int, nodeID string) {
+	benign, err := detection.LoadSamples(benignDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	encrypted, err := detection.LoadSamples(encryptedDir)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	model, err := detection.TrainClassifier(benign, encrypted, epochs, 0.5)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	correct := 0
+	for _, data := range benign {
+		if model.Score(data) < model.Threshold {
+			correct++
+		}
+	}
+	for _, data := range encrypted {
+		if model.Score(data) >= model.Threshold {
+			correct++
+		}
+	}
+
+	err = model.Save(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Trained on %d benign and %d encrypted samples, training accuracy %.2f%%\n",
+		len(benign), len(encrypted), 100*float64(correct)/float64(len(benign)+len(encrypted)))
+}
+
+func (cli *CommandLine) snapshot(dir, from, nodeID string) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is not Valid")
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	store, err := storage.NewStore(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	entries, err := snapshot.Take(absDir, store)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx, err := blockchain.NewSnapshotTransaction(from, absDir, entries)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	err = chain.AddSnapshotBlock(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Snapshot of %d files in %s anchored with root %x\n", len(tx.Manifest), absDir, tx.Root)
+}
+
+func (cli *CommandLine) restoreSnapshot(dir, root string, restore bool, nodeID string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Panic(err)
+	}
+	rootBytes, err := hex.DecodeString(root)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	tx, block, err := chain.FindSnapshot(rootBytes, absDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !tx.Validate() {
+		log.Panic("Snapshot manifest does not match its anchored root")
+	}
+
+	changes, err := snapshot.Diff(absDir, tx)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Snapshot %x from block %x (%d files)\n", tx.Root, block.Hash, len(tx.Manifest))
+	for _, change := range changes {
+		fmt.Printf(" %-8s %s\n", change.Kind, change.Path)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	if restore {
+		store, err := storage.NewStore(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		restored, err := snapshot.Restore(absDir, tx, changes, store)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Restored %d files\n", restored)
+	}
+}
+
+func (cli *CommandLine) rebuildFile(fileHash, out, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	tx, err := chain.FindFile(fileHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	store, err := storage.NewStore(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var data []byte
+	if info := tx.Erasure; info != nil {
+		shards, found := store.LoadShards(info.ShardHashes())
+		fmt.Printf("Found %d of %d shards, %d needed\n", found, len(shards), info.DataShards)
+		data, err = storage.DecodeShards(shards, info.DataShards, info.ParityShards, int(info.Size))
+	} else {
+		data, err = store.Get(fileHash)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if storage.HashData(data) != tx.FileHash {
+		log.Panic("Rebuilt file does not match its registered hash")
+	}
+
+	err = os.WriteFile(out, data, 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Rebuilt %s (%d bytes) to %s\n", tx.Filename, len(data), out)
+}
+
+// LoadChainParams switches to the chain params in the file named by the
+// CHAIN_PARAMS env. var., keeping the defaults when it is not set, and then
+// to the params stored with the node's chain if it has one. It is called
+// once at startup.
+func LoadChainParams(nodeID string) {
+	if path := os.Getenv("CHAIN_PARAMS"); path != "" {
+		params, err := blockchain.LoadParams(path)
+		if err != nil {
+			log.Panic(err)
+		}
+		blockchain.UseParams(params)
+	}
+	if err := blockchain.LoadStoredParams(nodeID); err != nil {
+		log.Panic(err)
+	}
+}
+
 func (cli *CommandLine) Run() {
 	cli.validateArgs()
 
@@ -170,6 +696,7 @@ func (cli *CommandLine) Run() {
 		fmt.Printf("NODE_ID env is not set!")
 		runtime.Goexit()
 	}
+	LoadChainParams(nodeID)
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
@@ -178,15 +705,63 @@ func (cli *CommandLine) Run() {
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	getTxCmd := flag.NewFlagSet("gettx", flag.ExitOnError)
+	addressHistoryCmd := flag.NewFlagSet("addresshistory", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	blocklistCmd := flag.NewFlagSet("blocklist", flag.ExitOnError)
+	listBlocklistCmd := flag.NewFlagSet("listblocklist", flag.ExitOnError)
+	voteValidatorCmd := flag.NewFlagSet("votevalidator", flag.ExitOnError)
+	listValidatorsCmd := flag.NewFlagSet("listvalidators", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	incidentReportCmd := flag.NewFlagSet("incidentreport", flag.ExitOnError)
+	plantCanaryCmd := flag.NewFlagSet("plantcanary", flag.ExitOnError)
+	trainClassifierCmd := flag.NewFlagSet("trainclassifier", flag.ExitOnError)
+	snapshotCmd := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	restoreSnapshotCmd := flag.NewFlagSet("restoresnapshot", flag.ExitOnError)
+	rebuildFileCmd := flag.NewFlagSet("rebuildfile", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	listAddressesKeys := listAddressesCmd.Bool("keys", false, "Also print the public key of each address")
+	voteFrom := voteValidatorCmd.String("from", "", "Validator address that signs the vote")
+	voteAdd := voteValidatorCmd.String("add", "", "Address to add as a validator")
+	voteRemove := voteValidatorCmd.String("remove", "", "Address to remove from the validators")
+	voteMine := voteValidatorCmd.Bool("mine", false, "Seal the vote on this node right away")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	estimateFeeBlocks := estimateFeeCmd.Int("blocks", blockchain.DefaultFeeTarget, "Blocks to confirm within")
+	sendFee := sendCmd.Int("fee", -1, "Fee paid to the miner, estimated when not set")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeReplicas := startNodeCmd.Int("replicas", network.DefaultReplicas, "Number of nodes that keep a copy of each uploaded file")
+	blocklistFrom := blocklistCmd.String("from", "", "Wallet address that signs the blocklist change")
+	blocklistAdd := blocklistCmd.String("add", "", "SHA-256 file hash to block")
+	blocklistRemove := blocklistCmd.String("remove", "", "SHA-256 file hash to unblock")
+	blocklistReason := blocklistCmd.String("reason", "", "Why the hash is blocked")
+	blocklistMine := blocklistCmd.Bool("mine", false, "Record the change on this node right away")
+	incidentFrom := incidentReportCmd.String("from", "", "Start of the window (RFC3339 or unix seconds, default 24h ago)")
+	incidentTo := incidentReportCmd.String("to", "", "End of the window (RFC3339 or unix seconds, default now)")
+	incidentOut := incidentReportCmd.String("out", "./reports", "Directory to write the report to")
+	canaryDir := plantCanaryCmd.String("dir", "./uploads", "Directory to plant the canary in")
+	trainBenign := trainClassifierCmd.String("benign", "", "Folder of benign sample files")
+	trainEncrypted := trainClassifierCmd.String("encrypted", "", "Folder of encrypted sample files")
+	trainEpochs := trainClassifierCmd.Int("epochs", 500, "Number of training passes over the samples")
+	snapshotDir := snapshotCmd.String("dir", "", "Directory to snapshot")
+	snapshotFrom := snapshotCmd.String("from", "", "Wallet address recorded as the snapshot owner")
+	restoreDir := restoreSnapshotCmd.String("dir", "", "Directory to compare")
+	restoreRoot := restoreSnapshotCmd.String("root", "", "Merkle root of the snapshot (default: latest snapshot of -dir)")
+	restoreWrite := restoreSnapshotCmd.Bool("restore", false, "Write modified and deleted files back from the snapshot")
+	rebuildHash := rebuildFileCmd.String("hash", "", "SHA-256 hash of the uploaded file")
+	rebuildOut := rebuildFileCmd.String("out", "", "Where to write the rebuilt file")
+	reindexTxDrop := reindexTxCmd.Bool("drop", false, "Remove the transaction index instead of building it")
+	historyAddress := addressHistoryCmd.String("address", "", "The address to list transactions for")
+	getTxID := getTxCmd.String("id", "", "Hex encoded transaction id")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block on the main chain")
+	getBlockTo := getBlockCmd.Int("to", -1, "Last height of a range of blocks")
 
 	switch os.Args[1] {
 	case "reindexutxo":
@@ -194,6 +769,21 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettx":
+		err := getTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "addresshistory":
+		err := addressHistoryCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -229,6 +819,71 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "blocklist":
+		err := blocklistCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listblocklist":
+		err := listBlocklistCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "votevalidator":
+		err := voteValidatorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listvalidators":
+		err := listValidatorsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "incidentreport":
+		err := incidentReportCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "plantcanary":
+		err := plantCanaryCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "trainclassifier":
+		err := trainClassifierCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "snapshot":
+		err := snapshotCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restoresnapshot":
+		err := restoreSnapshotCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "rebuildfile":
+		err := rebuildFileCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -243,7 +898,7 @@ func (cli *CommandLine) Run() {
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" && len(blockchain.Params.Allocations) == 0 {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
@@ -258,11 +913,28 @@ func (cli *CommandLine) Run() {
 		cli.createWallet(nodeID)
 	}
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(*listAddressesKeys, nodeID)
 	}
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
+	if reindexTxCmd.Parsed() {
+		cli.reindexTransactions(*reindexTxDrop, nodeID)
+	}
+	if getTxCmd.Parsed() {
+		if *getTxID == "" {
+			getTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTransaction(*getTxID, nodeID)
+	}
+	if addressHistoryCmd.Parsed() {
+		if *historyAddress == "" {
+			addressHistoryCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.addressHistory(*historyAddress, nodeID)
+	}
 
 	if sendCmd.Parsed() {
 		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
@@ -270,7 +942,91 @@ func (cli *CommandLine) Run() {
 			runtime.Goexit()
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeID, *sendMine)
+	}
+
+	if blocklistCmd.Parsed() {
+		if *blocklistFrom == "" || (*blocklistAdd == "") == (*blocklistRemove == "") {
+			blocklistCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.blocklist(*blocklistFrom, *blocklistAdd, *blocklistRemove, *blocklistReason, nodeID, *blocklistMine)
+	}
+
+	if listBlocklistCmd.Parsed() {
+		cli.listBlocklist(nodeID)
+	}
+
+	if voteValidatorCmd.Parsed() {
+		if *voteFrom == "" || (*voteAdd == "") == (*voteRemove == "") {
+			voteValidatorCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.voteValidator(*voteFrom, *voteAdd, *voteRemove, nodeID, *voteMine)
+	}
+
+	if listValidatorsCmd.Parsed() {
+		cli.listValidators(nodeID)
+	}
+
+	if supplyCmd.Parsed() {
+		cli.supply(nodeID)
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeBlocks < 1 || *estimateFeeBlocks > blockchain.MaxFeeTarget {
+			estimateFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.estimateFee(*estimateFeeBlocks, nodeID)
+	}
+
+	if incidentReportCmd.Parsed() {
+		cli.incidentReport(*incidentFrom, *incidentTo, *incidentOut, nodeID)
+	}
+
+	if plantCanaryCmd.Parsed() {
+		cli.plantCanary(*canaryDir, nodeID)
+	}
+
+	if trainClassifierCmd.Parsed() {
+		if *trainBenign == "" || *trainEncrypted == "" || *trainEpochs <= 0 {
+			trainClassifierCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.trainClassifier(*trainBenign, *trainEncrypted, *trainEpochs, nodeID)
+	}
+
+	if snapshotCmd.Parsed() {
+		if *snapshotDir == "" || *snapshotFrom == "" {
+			snapshotCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.snapshot(*snapshotDir, *snapshotFrom, nodeID)
+	}
+
+	if restoreSnapshotCmd.Parsed() {
+		if *restoreDir == "" {
+			restoreSnapshotCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.restoreSnapshot(*restoreDir, *restoreRoot, *restoreWrite, nodeID)
+	}
+
+	if rebuildFileCmd.Parsed() {
+		if *rebuildHash == "" || *rebuildOut == "" {
+			rebuildFileCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.rebuildFile(*rebuildHash, *rebuildOut, nodeID)
+	}
+
+	if getBlockCmd.Parsed() {
+		if *getBlockHeight < 0 {
+			getBlockCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlock(*getBlockHeight, *getBlockTo, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -279,6 +1035,7 @@ func (cli *CommandLine) Run() {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
+		network.Replicas = *startNodeReplicas
 		cli.StartNode(nodeID, *startNodeMiner)
 	}
 }
@@ -1,43 +1,26 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"math"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/detection"
+	"github.com/rudrasantadip/ransumgo/incident"
 	"github.com/rudrasantadip/ransumgo/network"
+	"github.com/rudrasantadip/ransumgo/storage"
 	"github.com/rudrasantadip/ransumgo/wallet"
 )
 
-func calculateEntropy(data []byte) float64 {
-	if len(data) == 0 {
-		return 0.0
-	}
-
-	var freq [256]int
-	for _, b := range data {
-		freq[b]++
-	}
-
-	entropy := 0.0
-	dataLen := float64(len(data))
-	for _, count := range freq {
-		if count == 0 {
-			continue
-		}
-		p := float64(count) / dataLen
-		entropy -= p * math.Log2(p)
-	}
-
-	return entropy
-}
-
 func (cli *CommandLine) CreateWalletHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
 	wallets, _ := wallet.CreateWallets(nodeID)
 	address := wallets.AddWallet()
@@ -73,7 +56,7 @@ func (cli *CommandLine) GetBalanceHandler(w http.ResponseWriter, r *http.Request
 
 func (cli *CommandLine) CreateBlockchainHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
 	address := r.URL.Query().Get("address")
-	if !wallet.ValidateAddress(address) {
+	if (address != "" || len(blockchain.Params.Allocations) == 0) && !wallet.ValidateAddress(address) {
 		http.Error(w, "Invalid address", http.StatusBadRequest)
 		return
 	}
@@ -100,6 +83,14 @@ func (cli *CommandLine) SendHandler(w http.ResponseWriter, r *http.Request, node
 		http.Error(w, "Invalid amount", http.StatusBadRequest)
 		return
 	}
+	fee := -1
+	if feeStr := r.URL.Query().Get("fee"); feeStr != "" {
+		fee, err = strconv.Atoi(feeStr)
+		if err != nil || fee < 0 {
+			http.Error(w, "Invalid fee", http.StatusBadRequest)
+			return
+		}
+	}
 
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
@@ -111,13 +102,28 @@ func (cli *CommandLine) SendHandler(w http.ResponseWriter, r *http.Request, node
 		return
 	}
 	wlt := wallets.GetWallet(from)
-	tx := blockchain.NewTransaction(&wlt, to, amount, &UTXOSet)
+	var tx *blockchain.Transaction
+	if fee < 0 {
+		tx = blockchain.NewTransactionAtRate(&wlt, to, amount, estimateFeeRate(chain), &UTXOSet)
+		if fee, err = UTXOSet.Fee(tx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		tx = blockchain.NewTransaction(&wlt, to, amount, fee, &UTXOSet)
+	}
 
 	if mine {
-		cbTx := blockchain.CoinbaseTx(from, "")
+		cbTx := blockchain.CoinbaseTx(from, "", chain.GetBestHeight()+1, fee)
 		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+		if err := chain.UseSigner(&wlt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := chain.MineBlock(txs, blockchain.Records{}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	} else {
 		network.SendTx(network.KnownNodes[0], tx)
 	}
@@ -137,6 +143,15 @@ func (cli *CommandLine) PrintChainHandler(w http.ResponseWriter, r *http.Request
 		for _, tx := range block.Transactions {
 			fmt.Fprintf(w, "%v\n", tx)
 		}
+		for _, tx := range block.FileTxs {
+			fmt.Fprintf(w, "%v\n", tx)
+		}
+		for _, tx := range block.BlocklistTxs {
+			fmt.Fprintf(w, "%v\n", tx)
+		}
+		for _, tx := range block.SnapshotTxs {
+			fmt.Fprintf(w, "%v\n", tx)
+		}
 		fmt.Fprintln(w, "")
 		if len(block.PrevHash) == 0 {
 			break
@@ -144,6 +159,114 @@ func (cli *CommandLine) PrintChainHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (cli *CommandLine) GetBlockHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	height, err := strconv.Atoi(r.URL.Query().Get("height"))
+	if err != nil || height < 0 {
+		http.Error(w, "Invalid height", http.StatusBadRequest)
+		return
+	}
+	to := height
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err = strconv.Atoi(toStr)
+		if err != nil || to < height {
+			http.Error(w, "Invalid height range", http.StatusBadRequest)
+			return
+		}
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	blocks, err := chain.GetBlocksByHeight(height, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(blocks) == 0 {
+		http.Error(w, fmt.Sprintf("No block at height %d", height), http.StatusNotFound)
+		return
+	}
+	for i := range blocks {
+		printBlock(w, &blocks[i])
+	}
+}
+
+func (cli *CommandLine) AddressHistoryHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	address := r.URL.Query().Get("address")
+	if !wallet.ValidateAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	history := chain.AddressHistory(pubKeyHash)
+
+	type entry struct {
+		TxID      string `json:"txid"`
+		BlockHash string `json:"block"`
+		Height    int    `json:"height"`
+		Timestamp int64  `json:"timestamp"`
+		Received  int    `json:"received"`
+		Sent      int    `json:"sent"`
+	}
+	entries := []entry{}
+	for _, e := range history.Entries {
+		entries = append(entries, entry{hex.EncodeToString(e.TxID), hex.EncodeToString(e.BlockHash), e.Height, e.Timestamp, e.Received, e.Sent})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":      address,
+		"transactions": entries,
+		"received":     history.Received,
+		"sent":         history.Sent,
+		"balance":      history.Balance,
+	})
+}
+
+func (cli *CommandLine) GetTxHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	txID, err := hex.DecodeString(r.URL.Query().Get("id"))
+	if err != nil || len(txID) == 0 {
+		http.Error(w, "Invalid transaction id", http.StatusBadRequest)
+		return
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	tx, block, confirmations, err := chain.GetTransaction(txID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintln(w, tx)
+	fmt.Fprintf(w, "Block: %x\n", block.Hash)
+	fmt.Fprintf(w, "Height: %d\n", block.Height)
+	fmt.Fprintf(w, "Confirmations: %d\n", confirmations)
+}
+
+func (cli *CommandLine) EstimateFeeHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	blocks := blockchain.DefaultFeeTarget
+	if blocksStr := r.URL.Query().Get("blocks"); blocksStr != "" {
+		var err error
+		blocks, err = strconv.Atoi(blocksStr)
+		if err != nil || blocks < 1 || blocks > blockchain.MaxFeeTarget {
+			http.Error(w, "Invalid block target", http.StatusBadRequest)
+			return
+		}
+	}
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	rate, err := chain.EstimateFee(blocks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "Fee rate to confirm within %d blocks: %d per 1000 bytes\n", blocks, rate)
+}
+
 func (cli *CommandLine) ReindexUTXOHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
@@ -156,6 +279,9 @@ func (cli *CommandLine) ReindexUTXOHandler(w http.ResponseWriter, r *http.Reques
 func (cli *CommandLine) StartNodeHandler(w http.ResponseWriter, r *http.Request) {
 	nodeID := r.URL.Query().Get("node")
 	miner := r.URL.Query().Get("miner")
+	if n, err := strconv.Atoi(r.URL.Query().Get("replicas")); err == nil && n > 0 {
+		network.Replicas = n
+	}
 	go cli.StartNode(nodeID, miner)
 	w.Write([]byte(fmt.Sprintf("Started node %s\n", nodeID)))
 }
@@ -201,10 +327,43 @@ func (cli *CommandLine) UploadFileHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	entropy := calculateEntropy(fileData)
-	fmt.Println(entropy)
-	if entropy >= 9.5 {
-		http.Error(w, fmt.Sprintf("⚠️ File rejected due to high entropy (%.2f). Possible ransomware or encrypted content.", entropy), http.StatusBadRequest)
+	// Continue blockchain instance
+	bc := blockchain.ContinueBlockChain(nodeID)
+	defer bc.Database.Close() // Ensure database closes after use
+
+	// Refuse anything already on the network blocklist before doing more work
+	fileHash := sha256.Sum256(fileData)
+	if entry, blocked := bc.IsBlocked(hex.EncodeToString(fileHash[:])); blocked {
+		incident.Record(nodeID, incident.Event{
+			Kind:      incident.EventBlocklist,
+			Timestamp: time.Now().Unix(),
+			Filename:  handler.Filename,
+			FileHash:  entry.FileHash,
+			Message:   "upload matched blocklist entry: " + entry.Reason,
+		})
+		http.Error(w, fmt.Sprintf("⛔ File rejected: hash %s is on the network blocklist (%s).", entry.FileHash, entry.Reason), http.StatusForbidden)
+		return
+	}
+
+	scan := detection.Scan(handler.Filename, fileData, detection.NodeDetectors(nodeID)...)
+	fmt.Printf("Scanned %s: %s\n%s\n", handler.Filename, scan.Verdict, scan)
+	if scan.Verdict != blockchain.VerdictClean {
+		var reasons []string
+		for _, d := range scan.Flagged() {
+			reasons = append(reasons, fmt.Sprintf("%s score %.2f", d.Detector, d.Score))
+		}
+		incident.Record(nodeID, incident.Event{
+			Kind:      incident.EventDetection,
+			Timestamp: scan.ScannedAt,
+			Filename:  handler.Filename,
+			FileHash:  hex.EncodeToString(fileHash[:]),
+			Message:   "upload rejected: " + strings.Join(reasons, ", "),
+			Scan:      &scan,
+		})
+		if _, err := incident.Quarantine(nodeID, handler.Filename, fileData, scan); err != nil {
+			fmt.Println("Could not quarantine upload:", err)
+		}
+		http.Error(w, fmt.Sprintf("⚠️ File rejected (%s). Possible ransomware or encrypted content.", strings.Join(reasons, ", ")), http.StatusBadRequest)
 		return
 	}
 
@@ -224,19 +383,149 @@ func (cli *CommandLine) UploadFileHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	store, err := storage.NewStore(nodeID)
+	if err != nil {
+		http.Error(w, "Could not open object store", http.StatusInternalServerError)
+		return
+	}
+
 	// Create blockchain transaction
-	tx := blockchain.NewFileUploadTransaction(from, handler.Filename, fileData, storagePath)
+	tx := blockchain.NewFileUploadTransaction(from, handler.Filename, fileData, storagePath, scan)
+	tx.ChunkRoot = storage.ChunkRoot(fileData)
+
+	// Keep the content in the node's object store so peers can replicate it,
+	// either whole or as data+parity erasure coded shards
+	dataShards, _ := strconv.Atoi(r.URL.Query().Get("data"))
+	parityShards, _ := strconv.Atoi(r.URL.Query().Get("parity"))
+	if dataShards > 0 {
+		shards, err := storage.EncodeShards(fileData, dataShards, parityShards)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hashes, err := store.StoreShards(shards)
+		if err != nil {
+			http.Error(w, "Could not store file shards", http.StatusInternalServerError)
+			return
+		}
+		tx.Erasure = &blockchain.ErasureInfo{
+			DataShards:   dataShards,
+			ParityShards: parityShards,
+			Size:         int64(len(fileData)),
+			Shards:       network.PlaceShards(tx.FileHash, hashes),
+		}
+		for i := range tx.Erasure.Shards {
+			tx.Erasure.Shards[i].Size = int64(len(shards[i]))
+			tx.Erasure.Shards[i].ChunkRoot = storage.ChunkRoot(shards[i])
+		}
+	} else if _, err := store.Put(fileData); err != nil {
+		http.Error(w, "Could not store file", http.StatusInternalServerError)
+		return
+	}
 
-	// Continue blockchain instance
-	bc := blockchain.ContinueBlockChain(nodeID)
-	defer bc.Database.Close() // Ensure database closes after use
+	// The signature covers the scan verdict and the shard placement above
+	uploader := wallets.GetWallet(from)
+	tx.Sign(&uploader)
 
-	err = bc.AddFileBlock(tx)
-	if err != nil {
-		http.Error(w, "Could not add block to blockchain", http.StatusInternalServerError)
+	if r.URL.Query().Get("mine") == "true" {
+		err = bc.AddFileBlock(tx)
+		if err != nil {
+			http.Error(w, "Could not add block to blockchain", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("✅ File uploaded and recorded with hash: %s", tx.FileHash)))
 		return
 	}
 
+	network.SendFileTx(network.KnownNodes[0], tx)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("✅ File uploaded and recorded with hash: %s", tx.FileHash)))
+	w.Write([]byte(fmt.Sprintf("✅ File uploaded and sent to the network with hash: %s", tx.FileHash)))
+}
+
+func (cli *CommandLine) BlocklistHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	from := r.URL.Query().Get("from")
+	action := r.URL.Query().Get("action")
+	fileHash := r.URL.Query().Get("hash")
+	reason := r.URL.Query().Get("reason")
+
+	if !wallet.ValidateAddress(from) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wlt := wallets.GetWallet(from)
+
+	tx, err := blockchain.NewBlocklistTransaction(&wlt, action, fileHash, reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("mine") != "true" {
+		network.SendBlocklistTx(network.KnownNodes[0], tx)
+		w.Write([]byte(fmt.Sprintf("Blocklist %s for %s sent to the network\n", tx.Action, tx.FileHash)))
+		return
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+	if err := chain.UseSigner(&wlt); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = chain.AddBlocklistBlock(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Blocklist %s recorded for %s\n", tx.Action, tx.FileHash)))
+}
+
+func (cli *CommandLine) ListBlocklistHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	var entries []blockchain.BlocklistEntry
+	for _, entry := range chain.Blocklist() {
+		entries = append(entries, entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (cli *CommandLine) IncidentReportHandler(w http.ResponseWriter, r *http.Request, nodeID string) {
+	now := time.Now().Unix()
+	from, err := incident.ParseTime(r.URL.Query().Get("from"), now-24*60*60)
+	if err != nil {
+		http.Error(w, "Invalid from time", http.StatusBadRequest)
+		return
+	}
+	to, err := incident.ParseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		http.Error(w, "Invalid to time", http.StatusBadRequest)
+		return
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Database.Close()
+
+	report, err := incident.BuildReport(chain, nodeID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		report.WriteHTML(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	report.WriteJSON(w)
 }
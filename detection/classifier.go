@@ -0,0 +1,224 @@
+package detection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+const (
+	classifierFile    = "./tmp/classifier_%s.json"
+	classifierVersion = "1.0.0"
+	// histogram, entropy, bigram coverage, chi-square uniformity, printable ratio
+	featureCount = 256 + 4
+)
+
+// Classifier is a logistic regression model over byte histogram and bigram
+// features. A score close to 1 means the content looks encrypted.
+type Classifier struct {
+	Version   string
+	Weights   []float64
+	Bias      float64
+	Threshold float64
+	Benign    int
+	Encrypted int
+}
+
+// Features turns raw bytes into the model's input vector
+func Features(data []byte) []float64 {
+	features := make([]float64, featureCount)
+	if len(data) == 0 {
+		return features
+	}
+
+	var freq [256]int
+	printable := 0
+	for _, b := range data {
+		freq[b]++
+		if (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t' {
+			printable++
+		}
+	}
+
+	n := float64(len(data))
+	expected := n / 256
+	chi := 0.0
+	for i, count := range freq {
+		features[i] = float64(count) / n * 256
+		d := float64(count) - expected
+		chi += d * d / expected
+	}
+
+	bigrams := make(map[uint16]struct{})
+	for i := 0; i+1 < len(data); i++ {
+		bigrams[uint16(data[i])<<8|uint16(data[i+1])] = struct{}{}
+	}
+	possible := math.Min(65536, math.Max(n-1, 1))
+
+	features[256] = CalculateEntropy(data) / 8
+	features[257] = float64(len(bigrams)) / possible
+	// Normalised so uniform random data lands near 0 and structured data near 1
+	features[258] = math.Min(chi/n, 1)
+	features[259] = float64(printable) / n
+
+	return features
+}
+
+// Score returns the probability that data is encrypted content
+func (c *Classifier) Score(data []byte) float64 {
+	return sigmoid(dot(c.Weights, Features(data)) + c.Bias)
+}
+
+// TrainClassifier fits a model with batch gradient descent on the two sample sets
+func TrainClassifier(benign, encrypted [][]byte, epochs int, learningRate float64) (*Classifier, error) {
+	if len(benign) == 0 || len(encrypted) == 0 {
+		return nil, errors.New("need at least one benign and one encrypted sample")
+	}
+
+	var xs [][]float64
+	var ys []float64
+	for _, data := range benign {
+		xs = append(xs, Features(data))
+		ys = append(ys, 0)
+	}
+	for _, data := range encrypted {
+		xs = append(xs, Features(data))
+		ys = append(ys, 1)
+	}
+
+	// Weight classes evenly so a lopsided sample set does not bias the model
+	classWeight := []float64{
+		float64(len(xs)) / (2 * float64(len(benign))),
+		float64(len(xs)) / (2 * float64(len(encrypted))),
+	}
+
+	model := &Classifier{
+		Version:   classifierVersion,
+		Weights:   make([]float64, featureCount),
+		Threshold: 0.5,
+		Benign:    len(benign),
+		Encrypted: len(encrypted),
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := range model.Weights {
+		model.Weights[i] = (rng.Float64() - 0.5) * 0.01
+	}
+
+	const l2 = 0.001
+	grad := make([]float64, featureCount)
+	for epoch := 0; epoch < epochs; epoch++ {
+		for i := range grad {
+			grad[i] = 0
+		}
+		gradBias := 0.0
+
+		for i, x := range xs {
+			p := sigmoid(dot(model.Weights, x) + model.Bias)
+			diff := (p - ys[i]) * classWeight[int(ys[i])]
+			for j, v := range x {
+				grad[j] += diff * v
+			}
+			gradBias += diff
+		}
+
+		n := float64(len(xs))
+		for j := range model.Weights {
+			model.Weights[j] -= learningRate * (grad[j]/n + l2*model.Weights[j])
+		}
+		model.Bias -= learningRate * gradBias / n
+	}
+
+	return model, nil
+}
+
+// LoadSamples reads every regular file under dir
+func LoadSamples(dir string) ([][]byte, error) {
+	var samples [][]byte
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			samples = append(samples, data)
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// Save writes the model for the node as JSON
+func (c *Classifier) Save(nodeID string) error {
+	path := fmt.Sprintf(classifierFile, nodeID)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadClassifier reads the node's saved model. It returns nil and no error
+// when no model has been trained yet.
+func LoadClassifier(nodeID string) (*Classifier, error) {
+	data, err := os.ReadFile(fmt.Sprintf(classifierFile, nodeID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var model Classifier
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, err
+	}
+	if len(model.Weights) != featureCount {
+		return nil, fmt.Errorf("classifier has %d weights, expected %d", len(model.Weights), featureCount)
+	}
+	return &model, nil
+}
+
+// ClassifierDetector adapts a trained Classifier to the Detector interface
+type ClassifierDetector struct {
+	Model *Classifier
+}
+
+func (d *ClassifierDetector) Name() string    { return "classifier" }
+func (d *ClassifierDetector) Version() string { return d.Model.Version }
+
+func (d *ClassifierDetector) Inspect(filename string, data []byte) blockchain.DetectorResult {
+	score := d.Model.Score(data)
+	return blockchain.DetectorResult{
+		Score:     score,
+		Threshold: d.Model.Threshold,
+		Flagged:   score >= d.Model.Threshold,
+	}
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,31 @@
+package detection
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainClassifier(t *testing.T) {
+	var benign, encrypted [][]byte
+	for i := 0; i < 8; i++ {
+		text := strings.Repeat("quarterly report: revenue grew in every region. ", 40+i*10)
+		benign = append(benign, []byte(text))
+
+		random := make([]byte, 2048+i*256)
+		rand.Read(random)
+		encrypted = append(encrypted, random)
+	}
+
+	model, err := TrainClassifier(benign, encrypted, 300, 0.5)
+	assert.NoError(t, err)
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	assert.Greater(t, model.Score(random), model.Threshold, "random bytes score as encrypted")
+
+	text := []byte(strings.Repeat("meeting notes and action items for the team. ", 60))
+	assert.Less(t, model.Score(text), model.Threshold, "plain text scores as benign")
+}
@@ -0,0 +1,58 @@
+package detection
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+// Detector inspects an uploaded file and reports a score
+type Detector interface {
+	Name() string
+	Version() string
+	Inspect(filename string, data []byte) blockchain.DetectorResult
+}
+
+// DefaultDetectors is the detector set used by the upload pipeline
+func DefaultDetectors() []Detector {
+	return []Detector{
+		NewEntropyDetector(),
+		NewRuleDetector(),
+	}
+}
+
+// NodeDetectors is the default set plus the node's trained classifier, if any
+func NodeDetectors(nodeID string) []Detector {
+	detectors := DefaultDetectors()
+	model, err := LoadClassifier(nodeID)
+	if err != nil {
+		fmt.Println("Ignoring classifier:", err)
+	} else if model != nil {
+		detectors = append(detectors, &ClassifierDetector{Model: model})
+	}
+	return detectors
+}
+
+// Scan runs every detector over the file. The verdict is malicious as soon as
+// one detector flags the file.
+func Scan(filename string, data []byte, detectors ...Detector) blockchain.ScanResult {
+	if len(detectors) == 0 {
+		detectors = DefaultDetectors()
+	}
+
+	result := blockchain.ScanResult{
+		Verdict:   blockchain.VerdictClean,
+		ScannedAt: time.Now().Unix(),
+	}
+	for _, d := range detectors {
+		res := d.Inspect(filename, data)
+		res.Detector = d.Name()
+		res.Version = d.Version()
+		if res.Flagged {
+			result.Verdict = blockchain.VerdictMalicious
+		}
+		result.Detectors = append(result.Detectors, res)
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package detection
+
+import (
+	"math"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+// EntropyThreshold is in bits per byte, which top out at 8. Encrypted and
+// compressed data comes close to that, text and most documents stay well below.
+const EntropyThreshold = 7.5
+
+// EntropyDetector flags files whose Shannon entropy is at or above Threshold
+type EntropyDetector struct {
+	Threshold float64
+}
+
+func NewEntropyDetector() *EntropyDetector {
+	return &EntropyDetector{Threshold: EntropyThreshold}
+}
+
+func (d *EntropyDetector) Name() string    { return "entropy" }
+func (d *EntropyDetector) Version() string { return "1.1.0" }
+
+func (d *EntropyDetector) Inspect(filename string, data []byte) blockchain.DetectorResult {
+	entropy := CalculateEntropy(data)
+	return blockchain.DetectorResult{
+		Score:     entropy,
+		Threshold: d.Threshold,
+		Flagged:   entropy >= d.Threshold,
+	}
+}
+
+// CalculateEntropy returns the Shannon entropy of data in bits per byte
+func CalculateEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0.0
+	}
+
+	var freq [256]int
+	for _, b := range data {
+		freq[b]++
+	}
+
+	entropy := 0.0
+	dataLen := float64(len(data))
+	for _, count := range freq {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / dataLen
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
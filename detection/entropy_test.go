@@ -0,0 +1,22 @@
+package detection
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntropyDetector(t *testing.T) {
+	detector := NewEntropyDetector()
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	result := detector.Inspect("data.bin", random)
+	assert.True(t, result.Flagged, "random bytes score %.4f", result.Score)
+	assert.LessOrEqual(t, result.Score, 8.0)
+
+	text := []byte(strings.Repeat("meeting notes and action items for the team. ", 60))
+	assert.False(t, detector.Inspect("notes.txt", text).Flagged)
+}
@@ -0,0 +1,61 @@
+package detection
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+// Rule matches either a filename extension or a byte pattern in the content
+type Rule struct {
+	Name      string
+	Extension string
+	Pattern   []byte
+}
+
+var defaultRules = []Rule{
+	{Name: "ext-locked", Extension: ".locked"},
+	{Name: "ext-encrypted", Extension: ".encrypted"},
+	{Name: "ext-crypt", Extension: ".crypt"},
+	{Name: "ext-wncry", Extension: ".wncry"},
+	{Name: "ext-lockbit", Extension: ".lockbit"},
+	{Name: "note-files-encrypted", Pattern: []byte("your files have been encrypted")},
+	{Name: "note-decrypt-payment", Pattern: []byte("pay for decryption")},
+	{Name: "note-tor-browser", Pattern: []byte("download tor browser")},
+	{Name: "note-restore-files", Pattern: []byte("to restore your files")},
+}
+
+// RuleDetector flags files matching known ransomware extensions or ransom note phrases
+type RuleDetector struct {
+	Rules []Rule
+}
+
+func NewRuleDetector() *RuleDetector {
+	return &RuleDetector{Rules: defaultRules}
+}
+
+func (d *RuleDetector) Name() string    { return "rules" }
+func (d *RuleDetector) Version() string { return "1.0.0" }
+
+func (d *RuleDetector) Inspect(filename string, data []byte) blockchain.DetectorResult {
+	ext := strings.ToLower(filepath.Ext(filename))
+	content := bytes.ToLower(data)
+
+	var matched []string
+	for _, rule := range d.Rules {
+		if rule.Extension != "" && ext == rule.Extension {
+			matched = append(matched, rule.Name)
+		} else if len(rule.Pattern) > 0 && bytes.Contains(content, rule.Pattern) {
+			matched = append(matched, rule.Name)
+		}
+	}
+
+	return blockchain.DetectorResult{
+		Score:        float64(len(matched)),
+		Threshold:    1,
+		MatchedRules: matched,
+		Flagged:      len(matched) > 0,
+	}
+}
@@ -0,0 +1,152 @@
+package incident
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/detection"
+)
+
+const (
+	canaryFile = "./tmp/canaries_%s.json"
+	canaryName = "accounts-backup.txt"
+)
+
+// Canary is a decoy file planted next to real data. Nothing legitimate
+// touches it, so a change to it is taken as ransomware at work.
+type Canary struct {
+	Path     string
+	FileHash string
+	Planted  int64
+}
+
+var canaryMu sync.Mutex
+
+// PlantCanary writes a decoy file into dir and starts watching it
+func PlantCanary(nodeID, dir string) (Canary, error) {
+	canaryMu.Lock()
+	defer canaryMu.Unlock()
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return Canary{}, err
+	}
+	// Plain text that compresses well, so an encrypted copy looks nothing like it
+	data := []byte(strings.Repeat("account,owner,balance\n", 256))
+	path := filepath.Join(dir, canaryName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return Canary{}, err
+	}
+
+	hash := sha256.Sum256(data)
+	canary := Canary{Path: path, FileHash: hex.EncodeToString(hash[:]), Planted: time.Now().Unix()}
+
+	canaries, err := loadCanaries(nodeID)
+	if err != nil {
+		return Canary{}, err
+	}
+	kept := canaries[:0]
+	for _, c := range canaries {
+		if c.Path != path {
+			kept = append(kept, c)
+		}
+	}
+	return canary, saveCanaries(nodeID, append(kept, canary))
+}
+
+// CheckCanaries records a canary event for every decoy that was changed or
+// removed since the last check and returns those events. A changed decoy
+// is watched from its new contents on, a removed one is dropped.
+func CheckCanaries(nodeID string) ([]Event, error) {
+	canaryMu.Lock()
+	defer canaryMu.Unlock()
+
+	canaries, err := loadCanaries(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var kept []Canary
+	for _, canary := range canaries {
+		event := Event{
+			Kind:      EventCanary,
+			Timestamp: time.Now().Unix(),
+			Filename:  filepath.Base(canary.Path),
+			Path:      canary.Path,
+		}
+
+		data, err := os.ReadFile(canary.Path)
+		switch {
+		case os.IsNotExist(err):
+			event.FileHash = canary.FileHash
+			event.Message = "canary file was deleted"
+		case err != nil:
+			return nil, err
+		default:
+			hash := sha256.Sum256(data)
+			current := hex.EncodeToString(hash[:])
+			kept = append(kept, canary)
+			if current == canary.FileHash {
+				continue
+			}
+			kept[len(kept)-1].FileHash = current
+			event.FileHash = current
+			event.Message = fmt.Sprintf("canary file was modified, entropy %.4f", detection.CalculateEntropy(data))
+		}
+
+		if err := Record(nodeID, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return events, saveCanaries(nodeID, kept)
+}
+
+// WatchCanaries checks the node's canaries every interval until the process exits
+func WatchCanaries(nodeID string, interval time.Duration) {
+	for range time.Tick(interval) {
+		events, err := CheckCanaries(nodeID)
+		if err != nil {
+			fmt.Printf("Canary check failed: %s\n", err)
+			continue
+		}
+		for _, event := range events {
+			fmt.Printf("⚠️ Canary alert for %s: %s\n", event.Path, event.Message)
+		}
+	}
+}
+
+func loadCanaries(nodeID string) ([]Canary, error) {
+	data, err := os.ReadFile(fmt.Sprintf(canaryFile, nodeID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var canaries []Canary
+	return canaries, json.Unmarshal(data, &canaries)
+}
+
+func saveCanaries(nodeID string, canaries []Canary) error {
+	path := fmt.Sprintf(canaryFile, nodeID)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(canaries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
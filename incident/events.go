@@ -0,0 +1,89 @@
+package incident
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+const eventFile = "./tmp/events_%s.log"
+
+const (
+	EventDetection  = "detection"
+	EventBlocklist  = "blocklist"
+	EventQuarantine = "quarantine"
+	EventCanary     = "canary"
+)
+
+// Event is a single entry in the node's append-only detection log
+type Event struct {
+	Kind      string
+	Timestamp int64
+	NodeID    string
+	Filename  string
+	FileHash  string
+	Message   string
+	Scan      *blockchain.ScanResult `json:",omitempty"`
+	Path      string                 `json:",omitempty"`
+}
+
+var logMu sync.Mutex
+
+// Record appends an event to the node's log as one JSON line
+func Record(nodeID string, event Event) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	event.NodeID = nodeID
+	path := fmt.Sprintf(eventFile, nodeID)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// LoadEvents returns the events recorded in [from, to], oldest first
+func LoadEvents(nodeID string, from, to int64) ([]Event, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	f, err := os.Open(fmt.Sprintf(eventFile, nodeID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, err
+		}
+		if event.Timestamp >= from && event.Timestamp <= to {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package incident
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inTempDir runs the test from an empty directory, the logs live under ./tmp
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestRecordAndLoadEvents(t *testing.T) {
+	inTempDir(t)
+	events, err := LoadEvents("1", 0, 1<<40)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	for ts := int64(10); ts <= 30; ts += 10 {
+		assert.NoError(t, Record("1", Event{Kind: EventDetection, Timestamp: ts, Filename: "a.bin"}))
+	}
+	assert.NoError(t, Record("2", Event{Kind: EventDetection, Timestamp: 20}))
+
+	events, err = LoadEvents("1", 15, 30)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, int64(20), events[0].Timestamp)
+	assert.Equal(t, "1", events[0].NodeID)
+}
+
+func TestCanaryAlerts(t *testing.T) {
+	dir := inTempDir(t)
+	canary, err := PlantCanary("1", filepath.Join(dir, "docs"))
+	assert.NoError(t, err)
+
+	events, err := CheckCanaries("1")
+	assert.NoError(t, err)
+	assert.Empty(t, events, "an untouched canary raises nothing")
+
+	assert.NoError(t, os.WriteFile(canary.Path, []byte{0x8f, 0x11, 0xe2, 0x5a}, 0644))
+	events, err = CheckCanaries("1")
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventCanary, events[0].Kind)
+	assert.NotEqual(t, canary.FileHash, events[0].FileHash)
+
+	// The same change is reported once
+	events, err = CheckCanaries("1")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	assert.NoError(t, os.Remove(canary.Path))
+	events, err = CheckCanaries("1")
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "canary file was deleted", events[0].Message)
+
+	logged, err := LoadEvents("1", 0, 1<<40)
+	assert.NoError(t, err)
+	assert.Len(t, logged, 2)
+}
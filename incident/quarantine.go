@@ -0,0 +1,41 @@
+package incident
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+const quarantineDir = "./quarantine/%s"
+
+// Quarantine keeps a rejected upload out of ./uploads under its hash, so it
+// can be inspected later without ever being served, and logs the event.
+func Quarantine(nodeID, filename string, data []byte, scan blockchain.ScanResult) (string, error) {
+	dir := fmt.Sprintf(quarantineDir, nodeID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	fileHash := hex.EncodeToString(hash[:])
+	path := filepath.Join(dir, fileHash+".quarantine")
+	if err := os.WriteFile(path, data, 0400); err != nil && !os.IsPermission(err) {
+		return "", err
+	}
+
+	err := Record(nodeID, Event{
+		Kind:      EventQuarantine,
+		Timestamp: time.Now().Unix(),
+		Filename:  filepath.Base(filename),
+		FileHash:  fileHash,
+		Message:   "upload moved to quarantine",
+		Scan:      &scan,
+		Path:      path,
+	})
+	return path, err
+}
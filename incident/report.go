@@ -0,0 +1,189 @@
+package incident
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+// ChainRecord is an on-chain entry cited as evidence in a report
+type ChainRecord struct {
+	Kind      string
+	BlockHash string
+	Height    int
+	Timestamp int64
+	FileHash  string
+	Filename  string
+	Detail    string
+	Scan      *blockchain.ScanResult `json:",omitempty"`
+}
+
+// Report gathers everything known about detection activity in a time window
+type Report struct {
+	NodeID       string
+	From         int64
+	To           int64
+	GeneratedAt  int64
+	TipHash      string
+	Detections   []Event
+	Quarantined  []Event
+	CanaryAlerts []Event
+	ChainRecords []ChainRecord
+}
+
+// BuildReport collects the node's events in [from, to] together with the
+// file and blocklist records on the chain for the same window. Blocklist
+// records for any hash mentioned in an event are included regardless of time.
+// Canaries are checked first so a report never misses a fresh alert.
+func BuildReport(chain *blockchain.BlockChain, nodeID string, from, to int64) (*Report, error) {
+	if _, err := CheckCanaries(nodeID); err != nil {
+		return nil, err
+	}
+	events, err := LoadEvents(nodeID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		NodeID:      nodeID,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now().Unix(),
+		TipHash:     hex.EncodeToString(chain.LastHash),
+	}
+
+	mentioned := make(map[string]bool)
+	for _, event := range events {
+		switch event.Kind {
+		case EventQuarantine:
+			report.Quarantined = append(report.Quarantined, event)
+		case EventCanary:
+			report.CanaryAlerts = append(report.CanaryAlerts, event)
+		default:
+			report.Detections = append(report.Detections, event)
+		}
+		if event.FileHash != "" {
+			mentioned[event.FileHash] = true
+		}
+	}
+
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		inWindow := block.Timestamp >= from && block.Timestamp <= to
+
+		for _, tx := range block.FileTxs {
+			if !inWindow && !mentioned[tx.FileHash] {
+				continue
+			}
+			scan := tx.Scan
+			report.ChainRecords = append(report.ChainRecords, ChainRecord{
+				Kind:      "file",
+				BlockHash: hex.EncodeToString(block.Hash),
+				Height:    block.Height,
+				Timestamp: block.Timestamp,
+				FileHash:  tx.FileHash,
+				Filename:  tx.Filename,
+				Detail:    "uploaded by " + tx.FromAddress,
+				Scan:      &scan,
+			})
+		}
+		for _, tx := range block.BlocklistTxs {
+			if !inWindow && !mentioned[tx.FileHash] {
+				continue
+			}
+			report.ChainRecords = append(report.ChainRecords, ChainRecord{
+				Kind:      "blocklist-" + tx.Action,
+				BlockHash: hex.EncodeToString(block.Hash),
+				Height:    block.Height,
+				Timestamp: block.Timestamp,
+				FileHash:  tx.FileHash,
+				Detail:    tx.Reason + " (signed by " + tx.Address() + ")",
+			})
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(ts int64) string { return time.Unix(ts, 0).UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <title>Incident report - node {{.NodeID}}</title>
+  <style>
+    body { font-family: "Segoe UI", sans-serif; background: #f5f7fa; color: #333; margin: 0; padding: 20px; }
+    h1, h2 { margin-bottom: 10px; }
+    table { border-collapse: collapse; width: 100%; background: #fff; margin-bottom: 30px; }
+    th, td { border: 1px solid #ddd; padding: 6px 8px; text-align: left; vertical-align: top; font-size: 13px; }
+    th { background: #eef3f7; }
+    code { font-family: monospace; word-break: break-all; }
+  </style>
+</head>
+<body>
+  <h1>Incident report</h1>
+  <p>Node {{.NodeID}}, window {{time .From}} to {{time .To}}, generated {{time .GeneratedAt}}.<br>
+  Chain tip at generation: <code>{{.TipHash}}</code></p>
+
+  <h2>Detection events ({{len .Detections}})</h2>
+  <table>
+    <tr><th>Time</th><th>Kind</th><th>File</th><th>SHA-256</th><th>Details</th></tr>
+    {{range .Detections}}<tr><td>{{time .Timestamp}}</td><td>{{.Kind}}</td><td>{{.Filename}}</td><td><code>{{.FileHash}}</code></td><td>{{.Message}}{{if .Scan}}<br>verdict {{.Scan.Verdict}}{{range .Scan.Detectors}}<br>{{.Detector}} {{.Version}}: {{printf "%.4f" .Score}}{{range .MatchedRules}} [{{.}}]{{end}}{{end}}{{end}}</td></tr>
+    {{end}}
+  </table>
+
+  <h2>Quarantined files ({{len .Quarantined}})</h2>
+  <table>
+    <tr><th>Time</th><th>File</th><th>SHA-256</th><th>Quarantine path</th></tr>
+    {{range .Quarantined}}<tr><td>{{time .Timestamp}}</td><td>{{.Filename}}</td><td><code>{{.FileHash}}</code></td><td><code>{{.Path}}</code></td></tr>
+    {{end}}
+  </table>
+
+  <h2>Canary alerts ({{len .CanaryAlerts}})</h2>
+  <table>
+    <tr><th>Time</th><th>File</th><th>SHA-256</th><th>Details</th></tr>
+    {{range .CanaryAlerts}}<tr><td>{{time .Timestamp}}</td><td>{{.Filename}}</td><td><code>{{.FileHash}}</code></td><td>{{.Message}}</td></tr>
+    {{end}}
+  </table>
+
+  <h2>Chain records ({{len .ChainRecords}})</h2>
+  <table>
+    <tr><th>Height</th><th>Block hash</th><th>Time</th><th>Kind</th><th>File</th><th>SHA-256</th><th>Details</th></tr>
+    {{range .ChainRecords}}<tr><td>{{.Height}}</td><td><code>{{.BlockHash}}</code></td><td>{{time .Timestamp}}</td><td>{{.Kind}}</td><td>{{.Filename}}</td><td><code>{{.FileHash}}</code></td><td>{{.Detail}}{{if .Scan}}<br>verdict {{.Scan.Verdict}}{{end}}</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// ParseTime accepts RFC3339 or unix seconds; an empty string yields def
+func ParseTime(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
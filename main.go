@@ -6,8 +6,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/rudrasantadip/ransumgo/cli"
+	"github.com/rudrasantadip/ransumgo/incident"
 )
 
 var commandLine = cli.CommandLine{}
@@ -18,6 +20,8 @@ func main() {
 		nodeID = "3000" // fallback node id
 		os.Setenv("NODE_ID", nodeID)
 	}
+	cli.LoadChainParams(nodeID)
+	go incident.WatchCanaries(nodeID, time.Minute)
 
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
@@ -46,10 +50,38 @@ func main() {
 		commandLine.PrintChainHandler(w, r, nodeID)
 	})
 
+	http.HandleFunc("/getblock", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.GetBlockHandler(w, r, nodeID)
+	})
+
+	http.HandleFunc("/addresshistory", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.AddressHistoryHandler(w, r, nodeID)
+	})
+
+	http.HandleFunc("/gettx", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.GetTxHandler(w, r, nodeID)
+	})
+
+	http.HandleFunc("/estimatefee", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.EstimateFeeHandler(w, r, nodeID)
+	})
+
 	http.HandleFunc("/reindexutxo", func(w http.ResponseWriter, r *http.Request) {
 		commandLine.ReindexUTXOHandler(w, r, nodeID)
 	})
 
+	http.HandleFunc("/blocklist", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.BlocklistHandler(w, r, nodeID)
+	})
+
+	http.HandleFunc("/listblocklist", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.ListBlocklistHandler(w, r, nodeID)
+	})
+
+	http.HandleFunc("/incidentreport", func(w http.ResponseWriter, r *http.Request) {
+		commandLine.IncidentReportHandler(w, r, nodeID)
+	})
+
 	http.HandleFunc("/startnode", func(w http.ResponseWriter, r *http.Request) {
 		commandLine.StartNodeHandler(w, r)
 	})
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+)
+
+var (
+	// shards fetched only to rebuild one of ours, dropped once that is done
+	borrowedShards = make(map[string]bool)
+	borrowedMu     sync.Mutex
+)
+
+// PlaceShards spreads the shards over the nodes we know of, in the same
+// ranking ReplicaNodes uses, wrapping around when there are fewer nodes
+// than shards
+func PlaceShards(fileHash string, hashes []string) []blockchain.ShardInfo {
+	nodes := ReplicaNodes(fileHash, len(hashes))
+
+	var shards []blockchain.ShardInfo
+	for i, hash := range hashes {
+		shard := blockchain.ShardInfo{Index: i, Hash: hash}
+		if len(nodes) > 0 {
+			shard.Node = nodes[i%len(nodes)]
+		}
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// missingShards lists the shards placed on this node that it does not hold
+func missingShards(tx *blockchain.FileUploadTransaction) []blockchain.ShardInfo {
+	var missing []blockchain.ShardInfo
+	for _, shard := range tx.Erasure.Shards {
+		if shard.Node == nodeAddress && !fileStore.Has(shard.Hash) {
+			missing = append(missing, shard)
+		}
+	}
+	return missing
+}
+
+// fetchShards asks peers for this node's missing shards. With rebuild set it
+// asks for every shard of the file, so a shard nobody else holds can be
+// recomputed from any DataShards of the others.
+func fetchShards(tx *blockchain.FileUploadTransaction, rebuild bool) {
+	missing := missingShards(tx)
+	if len(missing) == 0 {
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, shard := range missing {
+		wanted[shard.Hash] = true
+	}
+	if rebuild {
+		borrowedMu.Lock()
+		for _, shard := range tx.Erasure.Shards {
+			if !wanted[shard.Hash] && !fileStore.Has(shard.Hash) {
+				wanted[shard.Hash] = true
+				borrowedShards[shard.Hash] = true
+			}
+		}
+		borrowedMu.Unlock()
+	}
+
+	for hash := range wanted {
+		for _, node := range KnownNodes {
+			if node != nodeAddress {
+				SendGetFile(node, hash)
+			}
+		}
+	}
+}
+
+// completeShards recomputes this node's missing shards once enough of the
+// file's shards are present, then drops the borrowed ones
+func completeShards(tx *blockchain.FileUploadTransaction) {
+	info := tx.Erasure
+	missing := missingShards(tx)
+
+	hashes := info.ShardHashes()
+	shards, found := fileStore.LoadShards(hashes)
+	if len(missing) > 0 {
+		if found < info.DataShards {
+			return
+		}
+		_, err := storage.DecodeShards(shards, info.DataShards, info.ParityShards, int(info.Size))
+		if err != nil {
+			fmt.Printf("Could not rebuild shards of %s: %s\n", tx.FileHash, err)
+			return
+		}
+		for _, shard := range missing {
+			data := shards[shard.Index]
+			if storage.HashData(data) != shard.Hash {
+				fmt.Printf("Rebuilt shard %d of %s does not match the chain\n", shard.Index, tx.FileHash)
+				return
+			}
+			if _, err := fileStore.Put(data); err != nil {
+				fmt.Println("Could not store rebuilt shard:", err)
+				return
+			}
+			fmt.Printf("Rebuilt shard %d of %s\n", shard.Index, tx.FileHash)
+		}
+	}
+
+	borrowedMu.Lock()
+	defer borrowedMu.Unlock()
+	for _, hash := range hashes {
+		if borrowedShards[hash] {
+			fileStore.Delete(hash)
+			delete(borrowedShards, hash)
+		}
+	}
+}
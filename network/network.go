@@ -2,8 +2,10 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,9 +13,12 @@ import (
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
 
 	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+	"github.com/rudrasantadip/ransumgo/wallet"
 	"github.com/vrecan/death/v3"
 )
 
@@ -29,6 +34,21 @@ var (
 	KnownNodes      = []string{"localhost:3000"}
 	blocksInTransit = [][]byte{}
 	memoryPool      = make(map[string]blockchain.Transaction)
+	fileMemoryPool  = make(map[string]blockchain.FileUploadTransaction)
+	votePool        = make(map[string]blockchain.ValidatorVote)
+	blocklistPool   = make(map[string]blockchain.BlocklistTransaction)
+	feeEstimator    *blockchain.FeeEstimator // Set once the server opens the chain
+
+	// poolMu guards the memory pools and blocksInTransit, which every
+	// connection handler and the miner share
+	poolMu sync.Mutex
+
+	miningMu                sync.Mutex
+	miningCtx, cancelMining = context.WithCancel(context.Background())
+
+	// mineRequests wakes the miner goroutine. A request made while one is
+	// pending is merged into it, the miner takes the whole pool anyway.
+	mineRequests = make(chan struct{}, 1)
 )
 
 type Addr struct {
@@ -61,6 +81,21 @@ type Tx struct {
 	Transaction []byte
 }
 
+type FileTx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+type BlocklistTx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+type Vote struct {
+	AddrFrom string
+	Vote     []byte
+}
+
 type Version struct {
 	Version    int
 	BestHeight int
@@ -172,6 +207,30 @@ func SendTx(addr string, tnx *blockchain.Transaction) {
 	SendData(addr, request)
 }
 
+func SendFileTx(addr string, tnx *blockchain.FileUploadTransaction) {
+	data := FileTx{nodeAddress, tnx.Serialize()}
+	payload := GobEncode(data)
+	request := append(CmdToBytes("filetx"), payload...)
+
+	SendData(addr, request)
+}
+
+func SendBlocklistTx(addr string, tnx *blockchain.BlocklistTransaction) {
+	data := BlocklistTx{nodeAddress, tnx.Serialize()}
+	payload := GobEncode(data)
+	request := append(CmdToBytes("blocklisttx"), payload...)
+
+	SendData(addr, request)
+}
+
+func SendVote(addr string, vote *blockchain.ValidatorVote) {
+	data := Vote{nodeAddress, vote.Serialize()}
+	payload := GobEncode(data)
+	request := append(CmdToBytes("vote"), payload...)
+
+	SendData(addr, request)
+}
+
 func SendVersion(addr string, chain *blockchain.BlockChain) {
 	bestHeight := chain.GetBestHeight()
 	payload := GobEncode(Version{version, bestHeight, nodeAddress})
@@ -198,7 +257,15 @@ func HandleAddr(request []byte) {
 	RequestBlocks()
 }
 
-func HandleBlock(request []byte, chain *blockchain.BlockChain) {
+// HandleBlock adds a block relayed by peer, the host the connection came
+// from. Misbehaviour is scored against that host rather than the address the
+// message claims, which the sender could pick freely.
+func HandleBlock(request []byte, chain *blockchain.BlockChain, peer string) {
+	if IsBanned(peer) {
+		fmt.Printf("Ignoring block from banned peer %s\n", peer)
+		return
+	}
+
 	var buff bytes.Buffer
 	var payload Block
 
@@ -210,21 +277,55 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain) {
 	}
 
 	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	block, err := blockchain.DecodeBlock(blockData)
+	if err != nil {
+		RecordInvalidBlock(peer, err)
+		return
+	}
 
 	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
+	known := chain.HasBlock(block.Hash)
+	if err := chain.AddBlock(block); err != nil {
+		RecordInvalidBlock(peer, err)
+		poolMu.Lock()
+		blocksInTransit = [][]byte{}
+		poolMu.Unlock()
+		return
+	}
+	removeFromPools(block)
+	if !known && bytes.Equal(chain.LastHash, block.Hash) {
+		stopMining()
+		feeEstimator.ProcessBlock(block)
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
+	ReplicateFiles(block.FileTxs)
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
+	// The central node passes new tips on so every peer follows the same branch
+	if !known && nodeAddress == KnownNodes[0] && bytes.Equal(chain.LastHash, block.Hash) {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				SendInv(node, "block", [][]byte{block.Hash})
+			}
+		}
+	}
 
+	poolMu.Lock()
+	var blockHash []byte
+	if len(blocksInTransit) > 0 {
+		blockHash = blocksInTransit[0]
 		blocksInTransit = blocksInTransit[1:]
+	}
+	poolMu.Unlock()
+
+	if blockHash != nil {
+		SendGetData(payload.AddrFrom, "block", blockHash)
 	} else {
-		UTXOSet := blockchain.UTXOSet{chain}
-		UTXOSet.Reindex()
+		// A block on an unknown branch means we missed its ancestors
+		if !chain.HasBlock(block.PrevHash) && len(block.PrevHash) > 0 {
+			SendGetBlocks(payload.AddrFrom)
+		}
+		RepairStore(chain)
 	}
 }
 
@@ -242,27 +343,53 @@ func HandleInv(request []byte, chain *blockchain.BlockChain) {
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
 		blockHash := payload.Items[0]
-		SendGetData(payload.AddrFrom, "block", blockHash)
 
 		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
+		for _, b := range payload.Items {
 			if bytes.Compare(b, blockHash) != 0 {
 				newInTransit = append(newInTransit, b)
 			}
 		}
+		poolMu.Lock()
 		blocksInTransit = newInTransit
+		poolMu.Unlock()
+
+		SendGetData(payload.AddrFrom, "block", blockHash)
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		poolMu.Lock()
+		known := memoryPool[hex.EncodeToString(txID)].ID != nil
+		poolMu.Unlock()
+		if !known {
 			SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
+
+	if payload.Type == "filetx" {
+		txID := payload.Items[0]
+
+		poolMu.Lock()
+		_, ok := fileMemoryPool[hex.EncodeToString(txID)]
+		poolMu.Unlock()
+		if !ok {
+			SendGetData(payload.AddrFrom, "filetx", txID)
+		}
+	}
+
+	if payload.Type == "blocklisttx" {
+		txID := payload.Items[0]
+
+		poolMu.Lock()
+		_, ok := blocklistPool[hex.EncodeToString(txID)]
+		poolMu.Unlock()
+		if !ok {
+			SendGetData(payload.AddrFrom, "blocklisttx", txID)
+		}
+	}
 }
 
 func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) {
@@ -302,10 +429,36 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
+		poolMu.Lock()
 		tx := memoryPool[txID]
+		poolMu.Unlock()
 
 		SendTx(payload.AddrFrom, &tx)
 	}
+
+	if payload.Type == "filetx" {
+		txID := hex.EncodeToString(payload.ID)
+		poolMu.Lock()
+		tx, ok := fileMemoryPool[txID]
+		poolMu.Unlock()
+		if !ok {
+			return
+		}
+
+		SendFileTx(payload.AddrFrom, &tx)
+	}
+
+	if payload.Type == "blocklisttx" {
+		txID := hex.EncodeToString(payload.ID)
+		poolMu.Lock()
+		tx, ok := blocklistPool[txID]
+		poolMu.Unlock()
+		if !ok {
+			return
+		}
+
+		SendBlocklistTx(payload.AddrFrom, &tx)
+	}
 }
 
 func HandleTx(request []byte, chain *blockchain.BlockChain) {
@@ -320,10 +473,18 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 	}
 
 	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
+	tx, err := blockchain.DecodeTransaction(txData)
+	if err != nil {
+		fmt.Printf("Dropping transaction: %s\n", err)
+		return
+	}
+	poolMu.Lock()
 	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	pending := len(memoryPool)
+	poolMu.Unlock()
+	feeEstimator.Track(&tx)
 
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
+	fmt.Printf("%s, %d", nodeAddress, pending)
 
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
@@ -332,41 +493,274 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-			MineTx(chain)
+		if pending >= 2 && len(mineAddress) > 0 {
+			requestMining()
 		}
 	}
 }
 
+func HandleFileTx(request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload FileTx
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := blockchain.DecodeFileTransaction(payload.Transaction)
+	if err != nil {
+		fmt.Printf("Dropping file transaction: %s\n", err)
+		return
+	}
+	if err := (blockchain.Records{FileTxs: []*blockchain.FileUploadTransaction{&tx}}).Verify(); err != nil {
+		fmt.Printf("Dropping file transaction: %s\n", err)
+		return
+	}
+	if _, blocked := chain.IsBlocked(tx.FileHash); blocked {
+		fmt.Printf("Dropping file transaction for blocklisted hash %s\n", tx.FileHash)
+		return
+	}
+	txID := tx.Hash()
+	poolMu.Lock()
+	fileMemoryPool[hex.EncodeToString(txID)] = tx
+	pending := len(fileMemoryPool)
+	poolMu.Unlock()
+
+	fmt.Printf("%s, %d file txs\n", nodeAddress, pending)
+
+	if nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				SendInv(node, "filetx", [][]byte{txID})
+			}
+		}
+	} else {
+		if len(mineAddress) > 0 {
+			requestMining()
+		}
+	}
+}
+
+func HandleBlocklistTx(request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload BlocklistTx
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := blockchain.DecodeBlocklistTransaction(payload.Transaction)
+	if err != nil {
+		fmt.Printf("Dropping blocklist transaction: %s\n", err)
+		return
+	}
+	if err := (blockchain.Records{BlocklistTxs: []*blockchain.BlocklistTransaction{tx}}).Verify(); err != nil {
+		fmt.Printf("Dropping blocklist transaction: %s\n", err)
+		return
+	}
+	txID := tx.Hash()
+	poolMu.Lock()
+	blocklistPool[hex.EncodeToString(txID)] = *tx
+	pending := len(blocklistPool)
+	poolMu.Unlock()
+
+	fmt.Printf("%s, %d blocklist txs\n", nodeAddress, pending)
+
+	if nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				SendInv(node, "blocklisttx", [][]byte{txID})
+			}
+		}
+	} else {
+		if len(mineAddress) > 0 {
+			requestMining()
+		}
+	}
+}
+
+func HandleVote(request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload Vote
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	vote, err := blockchain.DecodeValidatorVote(payload.Vote)
+	if err != nil {
+		fmt.Printf("Dropping validator vote: %s\n", err)
+		return
+	}
+	if !vote.Verify() {
+		fmt.Println("Dropping validator vote with an invalid signature")
+		return
+	}
+	voteID := hex.EncodeToString(vote.Hash())
+	poolMu.Lock()
+	_, known := votePool[voteID]
+	votePool[voteID] = *vote
+	pending := len(votePool)
+	poolMu.Unlock()
+	if known {
+		return
+	}
+
+	fmt.Printf("%s, %d validator votes\n", nodeAddress, pending)
+
+	if nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				SendVote(node, vote)
+			}
+		}
+	} else {
+		if len(mineAddress) > 0 {
+			requestMining()
+		}
+	}
+}
+
+// removeFromPools drops everything a block confirmed from the memory pools
+func removeFromPools(block *blockchain.Block) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	for _, tx := range block.Transactions {
+		delete(memoryPool, hex.EncodeToString(tx.ID))
+	}
+	for _, tx := range block.FileTxs {
+		delete(fileMemoryPool, hex.EncodeToString(tx.Hash()))
+	}
+	for _, tx := range block.BlocklistTxs {
+		delete(blocklistPool, hex.EncodeToString(tx.Hash()))
+	}
+	for _, vote := range block.VoteTxs {
+		delete(votePool, hex.EncodeToString(vote.Hash()))
+	}
+}
+
+// miningContext is cancelled once the tip moves under the block being mined
+func miningContext() context.Context {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+	return miningCtx
+}
+
+// stopMining cancels every block being mined on the old tip
+func stopMining() {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+	cancelMining()
+	miningCtx, cancelMining = context.WithCancel(context.Background())
+}
+
+// requestMining asks the miner goroutine to mine the memory pools
+func requestMining() {
+	select {
+	case mineRequests <- struct{}{}:
+	default:
+	}
+}
+
+// miner is the only goroutine that mines, so blocks never compete with
+// each other for the same pool transactions
+func miner(chain *blockchain.BlockChain) {
+	for range mineRequests {
+		MineTx(chain)
+	}
+}
+
+// MineTx mines the memory pools into blocks until they are empty or it is
+// not this validator's turn
 func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
+	for mineBlock(chain) {
+	}
+}
 
+// mineBlock mines one block from the memory pools on the current tip and
+// reports whether there is more to mine
+func mineBlock(chain *blockchain.BlockChain) bool {
+	// The template, the coinbase height and the block all build on the
+	// same tip, whatever peers add meanwhile
+	tip, err := chain.Tip()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	poolMu.Lock()
+	var pool []*blockchain.Transaction
 	for id := range memoryPool {
 		fmt.Printf("tx: %s\n", memoryPool[id].ID)
 		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+		pool = append(pool, &tx)
 	}
 
-	if len(txs) == 0 {
+	var fileTxs []*blockchain.FileUploadTransaction
+	for id := range fileMemoryPool {
+		tx := fileMemoryPool[id]
+		fmt.Printf("filetx: %s\n", tx.FileHash)
+		fileTxs = append(fileTxs, &tx)
+	}
+
+	var blocklistTxs []*blockchain.BlocklistTransaction
+	for id := range blocklistPool {
+		tx := blocklistPool[id]
+		blocklistTxs = append(blocklistTxs, &tx)
+	}
+
+	var votes []*blockchain.ValidatorVote
+	for id := range votePool {
+		vote := votePool[id]
+		votes = append(votes, &vote)
+	}
+	poolMu.Unlock()
+
+	txs, fees, invalid := chain.BlockTemplateOn(tip.Hash, pool)
+	poolMu.Lock()
+	for _, tx := range invalid {
+		fmt.Printf("Dropping invalid transaction %x\n", tx.ID)
+		delete(memoryPool, hex.EncodeToString(tx.ID))
+	}
+	poolMu.Unlock()
+
+	if len(txs) == 0 && len(fileTxs) == 0 && len(blocklistTxs) == 0 && len(votes) == 0 {
 		fmt.Println("All Transactions are invalid")
-		return
+		return false
 	}
 
-	cbTx := blockchain.CoinbaseTx(mineAddress, "")
+	cbTx := blockchain.CoinbaseTx(mineAddress, "", tip.Height+1, fees)
 	txs = append(txs, cbTx)
 
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	newBlock, err := chain.MineBlockOn(miningContext(), &tip, txs, blockchain.Records{FileTxs: fileTxs, BlocklistTxs: blocklistTxs, VoteTxs: votes})
+	if errors.Is(err, context.Canceled) {
+		// A peer's block moved the tip, start over on top of it with
+		// whatever it left in the pools
+		fmt.Println("Tip changed, rebuilding the block template")
+		return true
+	}
+	if err == blockchain.ErrNotInTurn {
+		fmt.Println("Not this validator's turn, leaving the transactions to the next block")
+		return false
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Println("New Block mined")
+	ReplicateFiles(newBlock.FileTxs)
 
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
-	}
+	removeFromPools(newBlock)
+	feeEstimator.ProcessBlock(newBlock)
 
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
@@ -374,9 +768,9 @@ func MineTx(chain *blockchain.BlockChain) {
 		}
 	}
 
-	if len(memoryPool) > 0 {
-		MineTx(chain)
-	}
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return len(memoryPool) > 0 || len(fileMemoryPool) > 0 || len(blocklistPool) > 0 || len(votePool) > 0
 }
 
 func HandleVersion(request []byte, chain *blockchain.BlockChain) {
@@ -418,7 +812,7 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	case "addr":
 		HandleAddr(req)
 	case "block":
-		HandleBlock(req, chain)
+		HandleBlock(req, chain, peerHost(conn))
 	case "inv":
 		HandleInv(req, chain)
 	case "getblocks":
@@ -427,6 +821,20 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 		HandleGetData(req, chain)
 	case "tx":
 		HandleTx(req, chain)
+	case "filetx":
+		HandleFileTx(req, chain)
+	case "getfile":
+		HandleGetFile(req)
+	case "file":
+		HandleFile(req, chain)
+	case "challenge":
+		HandleChallenge(req)
+	case "proof":
+		HandleProof(req)
+	case "blocklisttx":
+		HandleBlocklistTx(req, chain)
+	case "vote":
+		HandleVote(req, chain)
 	case "version":
 		HandleVersion(req, chain)
 	default:
@@ -435,6 +843,16 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 
 }
 
+// peerHost is the host a connection came from. Outgoing connections use
+// ephemeral ports, so the port says nothing about who the peer is.
+func peerHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
 func StartServer(nodeID, minerAddress string) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	mineAddress = minerAddress
@@ -444,13 +862,38 @@ func StartServer(nodeID, minerAddress string) {
 	}
 	defer ln.Close()
 
+	fileStore, err = storage.NewStore(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	chain := blockchain.ContinueBlockChain(nodeID)
 	defer chain.Database.Close()
 	go CloseDB(chain)
+	feeEstimator = blockchain.NewFeeEstimator(chain)
+
+	if _, poa := chain.Engine.(*blockchain.ProofOfAuthorityEngine); poa && len(mineAddress) > 0 {
+		wallets, err := wallet.CreateWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		signer, ok := wallets.Wallets[mineAddress]
+		if !ok {
+			log.Panic("Miner address must be a local wallet to seal proof-of-authority blocks")
+		}
+		if err := chain.UseSigner(signer); err != nil {
+			log.Panic(err)
+		}
+	}
 
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
 	}
+	go RepairStore(chain)
+	go ChallengeLoop(chain)
+	if len(mineAddress) > 0 {
+		go miner(chain)
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
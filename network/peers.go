@@ -0,0 +1,92 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	proofReward  = 1
+	proofPenalty = 10
+	blockPenalty = 50
+
+	// BanScore is the score below which a peer's blocks are ignored
+	BanScore = -100
+)
+
+// PeerScore tracks how reliable a peer has been
+type PeerScore struct {
+	Address       string
+	Passed        int
+	Failed        int
+	InvalidBlocks int
+	Score         int
+	LastSeen      int64
+}
+
+var (
+	peerScores = make(map[string]*PeerScore)
+	peersMu    sync.Mutex
+)
+
+func peerScore(addr string) *PeerScore {
+	score, ok := peerScores[addr]
+	if !ok {
+		score = &PeerScore{Address: addr}
+		peerScores[addr] = score
+	}
+	return score
+}
+
+// RecordProof scores a peer on the outcome of a storage challenge
+func RecordProof(addr string, ok bool, reason string) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+
+	score := peerScore(addr)
+	score.LastSeen = time.Now().Unix()
+	if ok {
+		score.Passed++
+		score.Score += proofReward
+		return
+	}
+	score.Failed++
+	score.Score -= proofPenalty
+	fmt.Printf("Peer %s failed a storage challenge (%s), score now %d\n", addr, reason, score.Score)
+}
+
+// RecordInvalidBlock penalises a peer that relayed a block breaking consensus
+func RecordInvalidBlock(addr string, err error) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+
+	score := peerScore(addr)
+	score.LastSeen = time.Now().Unix()
+	score.InvalidBlocks++
+	score.Score -= blockPenalty
+	fmt.Printf("Peer %s sent an invalid block (%s), score now %d\n", addr, err, score.Score)
+}
+
+// IsBanned reports whether a peer misbehaved often enough to be ignored
+func IsBanned(addr string) bool {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+
+	score, ok := peerScores[addr]
+	return ok && score.Score < BanScore
+}
+
+// GetPeerScores returns every scored peer, best first
+func GetPeerScores() []PeerScore {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+
+	var scores []PeerScore
+	for _, score := range peerScores {
+		scores = append(scores, *score)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Score > scores[j].Score })
+	return scores
+}
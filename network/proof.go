@@ -0,0 +1,309 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+)
+
+const (
+	ChallengeInterval  = 60 * time.Second
+	ChallengeTimeout   = 30 * time.Second
+	challengesPerRound = 5
+	maxRangeLength     = 1024
+)
+
+// Challenge asks a peer to prove it still holds an object. With Length set
+// the peer hashes the nonce with that byte range; otherwise it returns chunk
+// Chunk with its Merkle path.
+type Challenge struct {
+	AddrFrom   string
+	ObjectHash string
+	Nonce      []byte
+	Offset     int64
+	Length     int64
+	Chunk      int
+}
+
+type Proof struct {
+	AddrFrom   string
+	ObjectHash string
+	Nonce      []byte
+	Missing    bool
+	Digest     []byte
+	ChunkData  []byte
+	ChunkPath  []blockchain.MerkleStep
+}
+
+// storedObject is a full file or a single shard together with where it should live
+type storedObject struct {
+	Hash      string
+	Size      int64
+	ChunkRoot []byte
+	Holders   []string
+}
+
+type pendingChallenge struct {
+	Peer     string
+	Object   storedObject
+	Expected []byte
+	Chunk    int
+	Sent     time.Time
+}
+
+var (
+	pendingChallenges = make(map[string]*pendingChallenge)
+	challengeMu       sync.Mutex
+)
+
+func SendChallenge(address string, challenge Challenge) {
+	payload := GobEncode(challenge)
+	request := append(CmdToBytes("challenge"), payload...)
+
+	SendData(address, request)
+}
+
+func SendProof(address string, proof Proof) {
+	payload := GobEncode(proof)
+	request := append(CmdToBytes("proof"), payload...)
+
+	SendData(address, request)
+}
+
+func HandleChallenge(request []byte) {
+	var buff bytes.Buffer
+	var payload Challenge
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+	// The range comes from the peer, so only ranges this node would ask
+	// for itself are answered
+	if payload.Offset < 0 || payload.Length < 0 || payload.Length > maxRangeLength {
+		fmt.Printf("Ignoring challenge for range %d+%d from %s\n", payload.Offset, payload.Length, payload.AddrFrom)
+		return
+	}
+
+	proof := Proof{AddrFrom: nodeAddress, ObjectHash: payload.ObjectHash, Nonce: payload.Nonce}
+	data, err := fileStore.Get(payload.ObjectHash)
+	if err != nil {
+		proof.Missing = true
+	} else if payload.Length > 0 {
+		proof.Digest = storage.RangeDigest(data, payload.Nonce, payload.Offset, payload.Length)
+	} else {
+		chunk, path, ok := storage.ChunkProof(data, payload.Chunk)
+		proof.Missing = !ok
+		proof.ChunkData = chunk
+		proof.ChunkPath = path
+	}
+
+	SendProof(payload.AddrFrom, proof)
+}
+
+func HandleProof(request []byte) {
+	var buff bytes.Buffer
+	var payload Proof
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	challengeMu.Lock()
+	key := hex.EncodeToString(payload.Nonce)
+	pending, ok := pendingChallenges[key]
+	if ok {
+		delete(pendingChallenges, key)
+	}
+	challengeMu.Unlock()
+
+	if !ok || pending.Object.Hash != payload.ObjectHash {
+		fmt.Printf("Ignoring unsolicited proof from %s\n", payload.AddrFrom)
+		return
+	}
+
+	switch {
+	case payload.Missing:
+		failChallenge(pending, "object missing")
+	case pending.Expected != nil:
+		if bytes.Equal(pending.Expected, payload.Digest) {
+			RecordProof(pending.Peer, true, "")
+		} else {
+			failChallenge(pending, "range digest mismatch")
+		}
+	default:
+		if blockchain.VerifyMerkleProof(pending.Object.ChunkRoot, payload.ChunkData, payload.ChunkPath) &&
+			len(payload.ChunkPath) == merkleDepth(storage.ChunkCount(pending.Object.Size)) &&
+			chunkIndex(payload.ChunkPath) == pending.Chunk {
+			RecordProof(pending.Peer, true, "")
+		} else {
+			failChallenge(pending, "chunk proof invalid")
+		}
+	}
+}
+
+// ChallengePeer sends one storage challenge for obj to peer. It checks a
+// random byte range when we hold the object ourselves and falls back to a
+// Merkle chunk proof against the on-chain chunk root otherwise.
+func ChallengePeer(peer string, obj storedObject) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Panic(err)
+	}
+
+	challenge := Challenge{AddrFrom: nodeAddress, ObjectHash: obj.Hash, Nonce: nonce}
+	pending := &pendingChallenge{Peer: peer, Object: obj, Sent: time.Now()}
+
+	if data, err := fileStore.Get(obj.Hash); err == nil {
+		challenge.Offset = randInt(int64(len(data)))
+		challenge.Length = maxRangeLength
+		pending.Expected = storage.RangeDigest(data, nonce, challenge.Offset, challenge.Length)
+	} else if len(obj.ChunkRoot) > 0 {
+		challenge.Chunk = int(randInt(int64(storage.ChunkCount(obj.Size))))
+		pending.Chunk = challenge.Chunk
+	} else {
+		return
+	}
+
+	challengeMu.Lock()
+	pendingChallenges[hex.EncodeToString(nonce)] = pending
+	challengeMu.Unlock()
+
+	SendChallenge(peer, challenge)
+}
+
+// ChallengeLoop periodically challenges the peers that should hold our
+// files and shards, and fails challenges that were never answered
+func ChallengeLoop(chain *blockchain.BlockChain) {
+	ticker := time.NewTicker(ChallengeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireChallenges()
+
+		var targets []struct {
+			peer string
+			obj  storedObject
+		}
+		for _, obj := range storedObjects(chain) {
+			for _, holder := range obj.Holders {
+				if holder != nodeAddress && holder != "" {
+					targets = append(targets, struct {
+						peer string
+						obj  storedObject
+					}{holder, obj})
+				}
+			}
+		}
+
+		for i := 0; i < challengesPerRound && len(targets) > 0; i++ {
+			pick := randInt(int64(len(targets)))
+			target := targets[pick]
+			targets = append(targets[:pick], targets[pick+1:]...)
+			ChallengePeer(target.peer, target.obj)
+		}
+	}
+}
+
+func expireChallenges() {
+	challengeMu.Lock()
+	var expired []*pendingChallenge
+	for key, pending := range pendingChallenges {
+		if time.Since(pending.Sent) > ChallengeTimeout {
+			expired = append(expired, pending)
+			delete(pendingChallenges, key)
+		}
+	}
+	challengeMu.Unlock()
+
+	for _, pending := range expired {
+		failChallenge(pending, "no response")
+	}
+}
+
+// storedObjects lists every full file and shard on the chain with the nodes
+// that are expected to hold it
+func storedObjects(chain *blockchain.BlockChain) []storedObject {
+	var objects []storedObject
+	for _, tx := range chain.Files() {
+		if tx.Erasure == nil {
+			objects = append(objects, storedObject{tx.FileHash, tx.Size, tx.ChunkRoot, ReplicaNodes(tx.FileHash, Replicas)})
+			continue
+		}
+		for _, shard := range tx.Erasure.Shards {
+			objects = append(objects, storedObject{shard.Hash, shard.Size, shard.ChunkRoot, []string{shard.Node}})
+		}
+	}
+	return objects
+}
+
+// failChallenge penalises the peer and pushes our copy of the object to a
+// node that does not hold it yet
+func failChallenge(pending *pendingChallenge, reason string) {
+	RecordProof(pending.Peer, false, fmt.Sprintf("%s for %s", reason, pending.Object.Hash))
+
+	data, err := fileStore.Get(pending.Object.Hash)
+	if err != nil {
+		fmt.Printf("Cannot re-replicate %s: no local copy\n", pending.Object.Hash)
+		return
+	}
+
+	holders := make(map[string]bool)
+	for _, holder := range pending.Object.Holders {
+		holders[holder] = true
+	}
+	for _, node := range ReplicaNodes(pending.Object.Hash, len(KnownNodes)+1) {
+		if node == nodeAddress || node == pending.Peer || holders[node] {
+			continue
+		}
+		fmt.Printf("Re-replicating %s to %s\n", pending.Object.Hash, node)
+		SendFile(node, pending.Object.Hash, data)
+		return
+	}
+	fmt.Printf("Cannot re-replicate %s: no spare node\n", pending.Object.Hash)
+}
+
+func merkleDepth(leaves int) int {
+	depth := 0
+	for leaves > 1 {
+		leaves = (leaves + 1) / 2
+		depth++
+	}
+	return depth
+}
+
+// chunkIndex recovers the leaf position a Merkle path proves
+func chunkIndex(path []blockchain.MerkleStep) int {
+	index := 0
+	for i, step := range path {
+		if step.Left {
+			index |= 1 << i
+		}
+	}
+	return index
+}
+
+func randInt(max int64) int64 {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(max))
+	if err != nil {
+		log.Panic(err)
+	}
+	return n.Int64()
+}
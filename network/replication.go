@@ -0,0 +1,167 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+)
+
+const DefaultReplicas = 2
+
+var (
+	// Replicas is how many nodes should hold a copy of every uploaded file
+	Replicas  = DefaultReplicas
+	fileStore *storage.Store
+)
+
+type GetFile struct {
+	AddrFrom string
+	FileHash string
+}
+
+type File struct {
+	AddrFrom string
+	FileHash string
+	Data     []byte
+}
+
+func SendGetFile(address, fileHash string) {
+	payload := GobEncode(GetFile{nodeAddress, fileHash})
+	request := append(CmdToBytes("getfile"), payload...)
+
+	SendData(address, request)
+}
+
+func SendFile(address, fileHash string, data []byte) {
+	payload := GobEncode(File{nodeAddress, fileHash, data})
+	request := append(CmdToBytes("file"), payload...)
+
+	SendData(address, request)
+}
+
+func HandleGetFile(request []byte) {
+	var buff bytes.Buffer
+	var payload GetFile
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	data, err := fileStore.Get(payload.FileHash)
+	if err != nil {
+		return
+	}
+	SendFile(payload.AddrFrom, payload.FileHash, data)
+}
+
+func HandleFile(request []byte, chain *blockchain.BlockChain) {
+	var buff bytes.Buffer
+	var payload File
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if storage.HashData(payload.Data) != payload.FileHash {
+		fmt.Printf("Dropping file from %s: content does not match %s\n", payload.AddrFrom, payload.FileHash)
+		return
+	}
+	if fileStore.Has(payload.FileHash) {
+		return
+	}
+	tx, index, err := chain.FindObject(payload.FileHash)
+	if err != nil {
+		fmt.Printf("Dropping file %s from %s: not registered on the chain\n", payload.FileHash, payload.AddrFrom)
+		return
+	}
+
+	_, err = fileStore.Put(payload.Data)
+	if err != nil {
+		log.Panic(err)
+	}
+	if index < 0 {
+		fmt.Printf("Stored replica of %s from %s\n", payload.FileHash, payload.AddrFrom)
+		return
+	}
+
+	fmt.Printf("Stored shard %d of %s from %s\n", index, tx.FileHash, payload.AddrFrom)
+	completeShards(tx)
+}
+
+// ReplicaNodes ranks every node we know of by hash(fileHash, address) and
+// returns the first n. All nodes with the same view agree on the set.
+func ReplicaNodes(fileHash string, n int) []string {
+	nodes := append([]string{}, KnownNodes...)
+	if nodeAddress != "" && !NodeIsKnown(nodeAddress) {
+		nodes = append(nodes, nodeAddress)
+	}
+
+	rank := func(node string) []byte {
+		hash := sha256.Sum256([]byte(fileHash + "|" + node))
+		return hash[:]
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(rank(nodes[i]), rank(nodes[j])) < 0
+	})
+
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// IsReplica reports whether this node should keep a copy of the file
+func IsReplica(fileHash string) bool {
+	for _, node := range ReplicaNodes(fileHash, Replicas) {
+		if node == nodeAddress {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplicateFiles asks peers for every file this node is responsible for but
+// does not hold yet
+func ReplicateFiles(files []*blockchain.FileUploadTransaction) {
+	for _, tx := range files {
+		if tx.Erasure != nil {
+			fetchShards(tx, false)
+			continue
+		}
+		if fileStore.Has(tx.FileHash) || !IsReplica(tx.FileHash) {
+			continue
+		}
+		for _, node := range KnownNodes {
+			if node != nodeAddress {
+				SendGetFile(node, tx.FileHash)
+			}
+		}
+	}
+}
+
+// RepairStore walks the file registry and fetches anything missing. A node
+// that lost its disk rebuilds its share of the store this way; lost shards
+// are recomputed from the shards the other nodes still hold.
+func RepairStore(chain *blockchain.BlockChain) {
+	var files []*blockchain.FileUploadTransaction
+	for _, tx := range chain.Files() {
+		if tx.Erasure != nil {
+			fetchShards(tx, true)
+			continue
+		}
+		files = append(files, tx)
+	}
+	ReplicateFiles(files)
+}
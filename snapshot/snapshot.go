@@ -0,0 +1,145 @@
+package snapshot
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+)
+
+const (
+	Added    = "added"
+	Modified = "modified"
+	Deleted  = "deleted"
+)
+
+// Change is a difference between a live directory and a snapshot
+type Change struct {
+	Path     string
+	Kind     string
+	Expected string // hash in the snapshot
+	Actual   string // hash on disk
+}
+
+// Take hashes every regular file under dir and copies its contents into the
+// node's object store so the snapshot can later be restored.
+func Take(dir string, store *storage.Store) ([]blockchain.SnapshotEntry, error) {
+	var entries []blockchain.SnapshotEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hash, size, err := store.PutFile(path)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, blockchain.SnapshotEntry{
+			Path:     filepath.ToSlash(rel),
+			FileHash: hash,
+			Size:     size,
+			Mode:     uint32(info.Mode().Perm()),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// Diff compares the live directory against a snapshot manifest
+func Diff(dir string, tx *blockchain.SnapshotTransaction) ([]Change, error) {
+	live := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		live[filepath.ToSlash(rel)] = storage.HashData(data)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, entry := range tx.Manifest {
+		actual, ok := live[entry.Path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{entry.Path, Deleted, entry.FileHash, ""})
+		case actual != entry.FileHash:
+			changes = append(changes, Change{entry.Path, Modified, entry.FileHash, actual})
+		}
+		delete(live, entry.Path)
+	}
+	for path, actual := range live {
+		changes = append(changes, Change{path, Added, "", actual})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Restore writes modified and deleted files back from the object store.
+// Files added since the snapshot are left in place and only reported.
+func Restore(dir string, tx *blockchain.SnapshotTransaction, changes []Change, store *storage.Store) (int, error) {
+	modes := make(map[string]uint32)
+	for _, entry := range tx.Manifest {
+		modes[entry.Path] = entry.Mode
+	}
+
+	restored := 0
+	for _, change := range changes {
+		if change.Kind == Added {
+			continue
+		}
+
+		if !blockchain.IsSnapshotPath(change.Path) {
+			return restored, fmt.Errorf("cannot restore %s: path leaves %s", change.Path, dir)
+		}
+		data, err := store.Get(change.Expected)
+		if err != nil {
+			return restored, fmt.Errorf("cannot restore %s: %w", change.Path, err)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(change.Path))
+		mode := os.FileMode(modes[change.Path])
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return restored, err
+		}
+		if err := os.WriteFile(path, data, mode); err != nil {
+			return restored, err
+		}
+		// WriteFile only applies the mode to files it creates
+		if err := os.Chmod(path, mode); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,49 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+	"github.com/rudrasantadip/ransumgo/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	store := &storage.Store{Dir: t.TempDir()}
+	path := filepath.Join(dir, "notes.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("original"), 0644))
+
+	entries, err := Take(dir, store)
+	assert.NoError(t, err)
+	tx, err := blockchain.NewSnapshotTransaction("", dir, entries)
+	assert.NoError(t, err)
+	assert.True(t, tx.Validate())
+
+	// The file is restored with its contents and its mode
+	assert.NoError(t, os.WriteFile(path, []byte("encrypted"), 0600))
+	assert.NoError(t, os.Chmod(path, 0600))
+	changes, err := Diff(dir, tx)
+	assert.NoError(t, err)
+	restored, err := Restore(dir, tx, changes, store)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, restored)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// Paths that leave the directory are refused
+	for _, bad := range []string{"../escape.txt", "/etc/passwd", "a/../../b", "./notes.txt", ""} {
+		_, err = Restore(dir, tx, []Change{{bad, Deleted, entries[0].FileHash, ""}}, store)
+		assert.Error(t, err, bad)
+
+		manifest := []blockchain.SnapshotEntry{{Path: bad, FileHash: entries[0].FileHash}}
+		forged := &blockchain.SnapshotTransaction{Manifest: manifest, Root: blockchain.SnapshotRoot(manifest)}
+		assert.False(t, forged.Validate(), bad)
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import "fmt"
+
+// EncodeShards splits data into dataShards data shards plus parityShards
+// Reed-Solomon parity shards
+func EncodeShards(data []byte, dataShards, parityShards int) ([][]byte, error) {
+	rs, err := NewReedSolomon(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	shards := rs.Split(data)
+	if err := rs.Encode(shards); err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// DecodeShards rebuilds the original data from any dataShards of the shards.
+// Missing shards are nil and are filled in on return.
+func DecodeShards(shards [][]byte, dataShards, parityShards int, size int) ([]byte, error) {
+	rs, err := NewReedSolomon(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.Reconstruct(shards); err != nil {
+		return nil, err
+	}
+	return rs.Join(shards, size)
+}
+
+// LoadShards reads the shards with the given hashes from the store, leaving
+// nil for anything missing or corrupt, and reports how many were found
+func (s *Store) LoadShards(hashes []string) ([][]byte, int) {
+	shards := make([][]byte, len(hashes))
+	found := 0
+	for i, hash := range hashes {
+		data, err := s.Get(hash)
+		if err != nil {
+			continue
+		}
+		shards[i] = data
+		found++
+	}
+	return shards, found
+}
+
+// StoreShards writes every shard to the store and returns their hashes
+func (s *Store) StoreShards(shards [][]byte) ([]string, error) {
+	var hashes []string
+	for i, shard := range shards {
+		hash, err := s.Put(shard)
+		if err != nil {
+			return nil, fmt.Errorf("storing shard %d: %w", i, err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErasureSurvivesNodeLoss(t *testing.T) {
+	const dataShards, parityShards = 4, 2
+
+	data := make([]byte, 10_000+7)
+	rand.Read(data)
+
+	shards, err := EncodeShards(data, dataShards, parityShards)
+	assert.NoError(t, err)
+
+	// One store per node, shard i lives on node i
+	nodes := make([]*Store, dataShards+parityShards)
+	hashes := make([]string, len(shards))
+	for i := range nodes {
+		nodes[i] = &Store{t.TempDir()}
+		hashes[i], err = nodes[i].Put(shards[i])
+		assert.NoError(t, err)
+	}
+
+	// Lose every possible pair of nodes and rebuild from the survivors
+	for a := 0; a < len(nodes); a++ {
+		for b := a + 1; b < len(nodes); b++ {
+			t.Run(fmt.Sprintf("lose-%d-%d", a, b), func(t *testing.T) {
+				collected := make([][]byte, len(nodes))
+				for i, node := range nodes {
+					if i == a || i == b {
+						continue
+					}
+					collected[i], err = node.Get(hashes[i])
+					assert.NoError(t, err)
+				}
+
+				rebuilt, err := DecodeShards(collected, dataShards, parityShards, len(data))
+				assert.NoError(t, err)
+				assert.Equal(t, data, rebuilt)
+				assert.Equal(t, HashData(shards[a]), HashData(collected[a]), "lost shard is regenerated")
+			})
+		}
+	}
+
+	// Losing more than the parity count is not recoverable
+	os.RemoveAll(nodes[0].Dir)
+	os.RemoveAll(nodes[1].Dir)
+	os.RemoveAll(nodes[2].Dir)
+	collected := make([][]byte, len(nodes))
+	for i := 3; i < len(nodes); i++ {
+		collected[i], _ = nodes[i].Get(hashes[i])
+	}
+	_, err = DecodeShards(collected, dataShards, parityShards, len(data))
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"crypto/sha256"
+
+	"github.com/rudrasantadip/ransumgo/blockchain"
+)
+
+// ChunkSize is the leaf size of the Merkle tree used for storage proofs
+const ChunkSize = 4096
+
+// SplitChunks cuts data into ChunkSize pieces; empty data is one empty chunk
+func SplitChunks(data []byte) [][]byte {
+	if len(data) == 0 {
+		return [][]byte{{}}
+	}
+	var chunks [][]byte
+	for start := 0; start < len(data); start += ChunkSize {
+		end := start + ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[start:end])
+	}
+	return chunks
+}
+
+// ChunkRoot is the Merkle root over the object's chunks. It is recorded
+// on-chain so a node without its own copy can still check storage proofs.
+func ChunkRoot(data []byte) []byte {
+	return blockchain.NewMerkleTree(SplitChunks(data)).RootNode.Data
+}
+
+// ChunkProof returns chunk index of data and its Merkle path
+func ChunkProof(data []byte, index int) ([]byte, []blockchain.MerkleStep, bool) {
+	chunks := SplitChunks(data)
+	if index < 0 || index >= len(chunks) {
+		return nil, nil, false
+	}
+	return chunks[index], blockchain.MerkleProof(chunks, index), true
+}
+
+// ChunkCount is how many chunks an object of size bytes has
+func ChunkCount(size int64) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + ChunkSize - 1) / ChunkSize)
+}
+
+// RangeDigest binds a byte range of the object to a challenge nonce. The
+// range is cut to the object, so any offset and length give a digest.
+func RangeDigest(data []byte, nonce []byte, offset, length int64) []byte {
+	size := int64(len(data))
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > size {
+		offset = size
+	}
+	if length < 0 {
+		length = 0
+	}
+	if length > size-offset {
+		length = size - offset
+	}
+	hash := sha256.Sum256(append(append([]byte{}, nonce...), data[offset:offset+length]...))
+	return hash[:]
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeDigestBounds(t *testing.T) {
+	data := []byte("0123456789")
+	nonce := []byte("nonce")
+	digest := func(part string) []byte {
+		hash := sha256.Sum256(append(append([]byte{}, nonce...), part...))
+		return hash[:]
+	}
+
+	assert.Equal(t, digest("2345"), RangeDigest(data, nonce, 2, 4))
+	assert.Equal(t, digest("89"), RangeDigest(data, nonce, 8, 100))
+
+	// Hostile ranges are cut to the object instead of slicing out of it
+	assert.Equal(t, digest("0123"), RangeDigest(data, nonce, -1, 4))
+	assert.Equal(t, digest("56789"), RangeDigest(data, nonce, 5, math.MaxInt64))
+	assert.Equal(t, digest("0123456789"), RangeDigest(data, nonce, math.MinInt64, math.MaxInt64))
+	assert.Equal(t, digest(""), RangeDigest(data, nonce, math.MaxInt64, math.MaxInt64))
+	assert.Equal(t, digest(""), RangeDigest(data, nonce, 3, -5))
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reed-Solomon over GF(2^8) with the 0x11d polynomial. The encoding matrix
+// is a Vandermonde matrix turned systematic, so the first k shards are the
+// data itself and any k of the k+m shards are enough to rebuild it.
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])-int(gfLog[b])+255)%255]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for r := range m {
+		for c := range other[0] {
+			var v byte
+			for i := range m[r] {
+				v ^= gfMul(m[r][i], other[i][c])
+			}
+			result[r][c] = v
+		}
+	}
+	return result
+}
+
+// invert uses Gauss-Jordan elimination on a square matrix
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := work[col][col]
+		for c := range work[col] {
+			work[col][c] = gfDiv(work[col][c], scale)
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := range work[r] {
+				work[r][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inverse := newMatrix(n, n)
+	for r := range inverse {
+		copy(inverse[r], work[r][n:])
+	}
+	return inverse, nil
+}
+
+// ReedSolomon encodes data into DataShards + ParityShards shards
+type ReedSolomon struct {
+	DataShards   int
+	ParityShards int
+	matrix       matrix
+}
+
+func NewReedSolomon(dataShards, parityShards int) (*ReedSolomon, error) {
+	if dataShards <= 0 || parityShards < 0 {
+		return nil, errors.New("need at least one data shard and no negative parity")
+	}
+	total := dataShards + parityShards
+	if total > 256 {
+		return nil, fmt.Errorf("at most 256 shards, got %d", total)
+	}
+
+	vandermonde := newMatrix(total, dataShards)
+	for r := range vandermonde {
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top, err := vandermonde[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReedSolomon{dataShards, parityShards, vandermonde.multiply(top)}, nil
+}
+
+func (rs *ReedSolomon) totalShards() int {
+	return rs.DataShards + rs.ParityShards
+}
+
+// Split cuts data into equally sized, zero padded data shards and allocates
+// empty parity shards. Call Encode to fill in the parity.
+func (rs *ReedSolomon) Split(data []byte) [][]byte {
+	size := (len(data) + rs.DataShards - 1) / rs.DataShards
+	if size == 0 {
+		size = 1
+	}
+
+	padded := make([]byte, size*rs.totalShards())
+	copy(padded, data)
+
+	shards := make([][]byte, rs.totalShards())
+	for i := range shards {
+		shards[i] = padded[i*size : (i+1)*size : (i+1)*size]
+	}
+	return shards
+}
+
+// Encode computes the parity shards from the data shards
+func (rs *ReedSolomon) Encode(shards [][]byte) error {
+	if len(shards) != rs.totalShards() {
+		return fmt.Errorf("expected %d shards, got %d", rs.totalShards(), len(shards))
+	}
+	size := len(shards[0])
+	for _, shard := range shards {
+		if len(shard) != size {
+			return errors.New("shards must all be the same size")
+		}
+	}
+
+	for p := rs.DataShards; p < rs.totalShards(); p++ {
+		rs.codeRow(rs.matrix[p], shards[:rs.DataShards], shards[p])
+	}
+	return nil
+}
+
+func (rs *ReedSolomon) codeRow(row []byte, inputs [][]byte, output []byte) {
+	for i := range output {
+		output[i] = 0
+	}
+	for c, input := range inputs {
+		factor := row[c]
+		if factor == 0 {
+			continue
+		}
+		for i, b := range input {
+			output[i] ^= gfMul(factor, b)
+		}
+	}
+}
+
+// Reconstruct fills in every nil shard. At least DataShards shards must be present.
+func (rs *ReedSolomon) Reconstruct(shards [][]byte) error {
+	if len(shards) != rs.totalShards() {
+		return fmt.Errorf("expected %d shards, got %d", rs.totalShards(), len(shards))
+	}
+
+	var present []int
+	size := 0
+	for i, shard := range shards {
+		if shard != nil {
+			present = append(present, i)
+			size = len(shard)
+		}
+	}
+	if len(present) < rs.DataShards {
+		return fmt.Errorf("need %d shards to reconstruct, have %d", rs.DataShards, len(present))
+	}
+	present = present[:rs.DataShards]
+
+	sub := newMatrix(rs.DataShards, rs.DataShards)
+	inputs := make([][]byte, rs.DataShards)
+	for i, idx := range present {
+		if len(shards[idx]) != size {
+			return errors.New("shards must all be the same size")
+		}
+		copy(sub[i], rs.matrix[idx])
+		inputs[i] = shards[idx]
+	}
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < rs.DataShards; d++ {
+		if shards[d] == nil {
+			shards[d] = make([]byte, size)
+			rs.codeRow(decode[d], inputs, shards[d])
+		}
+	}
+	for p := rs.DataShards; p < rs.totalShards(); p++ {
+		if shards[p] == nil {
+			shards[p] = make([]byte, size)
+			rs.codeRow(rs.matrix[p], shards[:rs.DataShards], shards[p])
+		}
+	}
+	return nil
+}
+
+// Join concatenates the data shards and trims the padding
+func (rs *ReedSolomon) Join(shards [][]byte, size int) ([]byte, error) {
+	var data []byte
+	for _, shard := range shards[:rs.DataShards] {
+		if shard == nil {
+			return nil, errors.New("missing data shard, reconstruct first")
+		}
+		data = append(data, shard...)
+	}
+	if size > len(data) {
+		return nil, errors.New("shards are shorter than the original data")
+	}
+	return data[:size], nil
+}
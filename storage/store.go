@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const storeDir = "./tmp/objects_%s"
+
+var ErrNotFound = errors.New("object not found")
+
+// Store keeps file contents on disk addressed by their hex SHA-256 hash
+type Store struct {
+	Dir string
+}
+
+func NewStore(nodeID string) (*Store, error) {
+	dir := fmt.Sprintf(storeDir, nodeID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Store{dir}, nil
+}
+
+// HashData returns the hex SHA-256 digest used as an object key
+func HashData(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.Dir, hash)
+}
+
+// Put stores data and returns its hash
+func (s *Store) Put(data []byte) (string, error) {
+	hash := HashData(data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, "put-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), s.path(hash))
+}
+
+// PutFile streams a file into the store and returns its hash
+func (s *Store) PutFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp(s.Dir, "put-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), f)
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if s.Has(hash) {
+		return hash, size, nil
+	}
+	return hash, size, os.Rename(tmp.Name(), s.path(hash))
+}
+
+// Get returns the stored bytes and checks they still match the hash
+func (s *Store) Get(hash string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if HashData(data) != hash {
+		return nil, fmt.Errorf("object %s is corrupt", hash)
+	}
+	return data, nil
+}
+
+func (s *Store) Has(hash string) bool {
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+func (s *Store) Delete(hash string) error {
+	err := os.Remove(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
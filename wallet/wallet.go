@@ -6,6 +6,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"log"
 	"math/big"
 	"net/http"
@@ -15,9 +16,19 @@ import (
 
 const (
 	checksumLength = 4
-	version        = byte(0x00)
+	// PublicKeyLength is the length of a public key with both coordinates
+	// padded to 32 bytes
+	PublicKeyLength = 64
 )
 
+// ErrShortKey is returned for wallets created before public keys were
+// padded. Padding the key would change its address, so such a wallet
+// cannot be migrated and must be replaced.
+var ErrShortKey = errors.New("wallet has an unpadded public key, create a new wallet")
+
+// Version is the address version byte of the chain in use
+var Version = byte(0x00)
+
 // Wallet stores only serializable fields
 type Wallet struct {
 	PrivateKey []byte // D scalar
@@ -42,7 +53,7 @@ func (w Wallet) WriteHeader(statusCode int) {
 // Address generates the Base58 address from public key
 func (w Wallet) Address() []byte {
 	pubHash := PublicKeyHash(w.PublicKey)
-	versionedHash := append([]byte{version}, pubHash...)
+	versionedHash := append([]byte{Version}, pubHash...)
 	checksum := Checksum(versionedHash)
 	fullHash := append(versionedHash, checksum...)
 	address := Base58Encode(fullHash)
@@ -63,10 +74,21 @@ func NewKeyPair() ([]byte, []byte) {
 		log.Panic(err)
 	}
 	privBytes := private.D.Bytes()
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// Both coordinates are padded so the key splits evenly when verifying
+	pub := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pub[:32])
+	private.PublicKey.Y.FillBytes(pub[32:])
 	return privBytes, pub
 }
 
+// CheckKey reports whether the wallet's public key can be verified by others
+func (w Wallet) CheckKey() error {
+	if len(w.PublicKey) != PublicKeyLength {
+		return ErrShortKey
+	}
+	return nil
+}
+
 // ReconstructECDSAKey rebuilds ECDSA key from D and public key
 func (w *Wallet) ReconstructECDSAKey() *ecdsa.PrivateKey {
 	priv := new(ecdsa.PrivateKey)
@@ -94,11 +116,19 @@ func Checksum(payload []byte) []byte {
 	return second[:checksumLength]
 }
 
-// ValidateAddress validates a given wallet address
+// ValidateAddress validates a given wallet address for the chain in use
 func ValidateAddress(address string) bool {
+	return ValidateAddressVersion(address, Version)
+}
+
+// ValidateAddressVersion validates an address of the chain whose address
+// version byte is version
+func ValidateAddressVersion(address string, version byte) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= 1+checksumLength || pubKeyHash[0] != version {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
 	return bytes.Compare(actualChecksum, targetChecksum) == 0